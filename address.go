@@ -0,0 +1,318 @@
+package tfclean
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// address is a Terraform address as written in the from and to of moved,
+// import and removed blocks: a module path, optionally followed by a resource.
+// An address without a resource refers to a module. It is built from the HCL
+// traversal rather than from text, so instance keys containing dots or
+// brackets are kept intact.
+type address struct {
+	modules  []moduleStep
+	resource *resourceAddress
+}
+
+// moduleStep is one module call in a module path, such as module.foo["a"].
+type moduleStep struct {
+	name string
+	key  *instanceKey
+}
+
+// resourceAddress is the resource part of an address, such as
+// data.aws_ami.this or aws_instance.web[0].
+type resourceAddress struct {
+	mode string // "managed" or "data"
+	typ  string
+	name string
+	key  *instanceKey
+}
+
+// instanceKey is the count or for_each key of a module or resource instance.
+type instanceKey struct {
+	str   string
+	num   int
+	isNum bool
+}
+
+func (k *instanceKey) String() string {
+	if k.isNum {
+		return fmt.Sprintf("[%d]", k.num)
+	}
+	// State addresses quote string keys the same way as JSON.
+	quoted, _ := json.Marshal(k.str)
+	return "[" + string(quoted) + "]"
+}
+
+func (a address) isModule() bool {
+	return a.resource == nil
+}
+
+// keyMatch controls how a module step written without an instance key is
+// compared with the steps of state addresses.
+type keyMatch int
+
+const (
+	// exactKeys matches a keyless step only against the keyless instance.
+	exactKeys keyMatch = iota
+	// anyKeyLastStep lets a keyless last step match every instance of the
+	// module call, as when a moved block moves a whole call.
+	anyKeyLastStep
+	// anyKeyAllSteps lets every keyless step match every instance, as in
+	// removed blocks, which cannot carry instance keys.
+	anyKeyAllSteps
+)
+
+// containsModuleOf reports whether other lies under the module path of a, at
+// any depth.
+func (a address) containsModuleOf(other address, match keyMatch) bool {
+	if len(other.modules) < len(a.modules) {
+		return false
+	}
+	last := len(a.modules) - 1
+	for i, step := range a.modules {
+		got := other.modules[i]
+		if got.name != step.name {
+			return false
+		}
+		anyKey := match == anyKeyAllSteps || match == anyKeyLastStep && i == last
+		if !keyMatches(step.key, got.key, anyKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesResource reports whether other is an instance of the resource a
+// refers to. The resource is the last step, so with anyKeyLastStep a resource
+// written without a key matches every instance of it, while module steps
+// keep their exact keys.
+func (a address) matchesResource(other address, match keyMatch) bool {
+	if a.resource == nil || other.resource == nil || len(other.modules) != len(a.modules) {
+		return false
+	}
+	for i, step := range a.modules {
+		got := other.modules[i]
+		if got.name != step.name || !keyMatches(step.key, got.key, match == anyKeyAllSteps) {
+			return false
+		}
+	}
+	want, got := a.resource, other.resource
+	if want.mode != got.mode || want.typ != got.typ || want.name != got.name {
+		return false
+	}
+	return keyMatches(want.key, got.key, match != exactKeys)
+}
+
+// keyMatches compares the instance key written in an address with the key of
+// a state address. anyKey lets a step without a key match every instance.
+func keyMatches(want, got *instanceKey, anyKey bool) bool {
+	switch {
+	case want != nil:
+		return got != nil && *got == *want
+	case got == nil:
+		// Keyless step and keyless instance.
+		return true
+	default:
+		return anyKey
+	}
+}
+
+// String renders the address the way state addresses are written, e.g.
+// module.foo["a"].aws_instance.web[0].
+func (a address) String() string {
+	var parts []string
+	for _, m := range a.modules {
+		part := "module." + m.name
+		if m.key != nil {
+			part += m.key.String()
+		}
+		parts = append(parts, part)
+	}
+	if r := a.resource; r != nil {
+		part := r.typ + "." + r.name
+		if r.mode == "data" {
+			part = "data." + part
+		}
+		if r.key != nil {
+			part += r.key.String()
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// evalAddress builds an address from a reference whose instance keys may be
+// expressions, such as aws_s3_bucket.this[each.key] in an import block with
+// for_each. The keys are evaluated in ctx; with a nil ctx only literal keys
+// are accepted.
+func evalAddress(expr hclsyntax.Expression, ctx *hcl.EvalContext) (address, error) {
+	traversal, err := addressTraversal(expr, ctx)
+	if err != nil {
+		return address{}, err
+	}
+	return parseTraversal(traversal)
+}
+
+func addressTraversal(expr hclsyntax.Expression, ctx *hcl.EvalContext) (hcl.Traversal, error) {
+	switch expr := expr.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		return expr.Traversal, nil
+	case *hclsyntax.RelativeTraversalExpr:
+		source, err := addressTraversal(expr.Source, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return append(append(hcl.Traversal{}, source...), expr.Traversal...), nil
+	case *hclsyntax.IndexExpr:
+		collection, err := addressTraversal(expr.Collection, ctx)
+		if err != nil {
+			return nil, err
+		}
+		key, diags := expr.Key.Value(ctx)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("cannot evaluate instance key: %s", diags)
+		}
+		return append(append(hcl.Traversal{}, collection...), hcl.TraverseIndex{Key: key}), nil
+	}
+	return nil, fmt.Errorf("expected a reference such as module.foo or aws_instance.bar, got %T", expr)
+}
+
+// parseAddressString parses an address given as text, e.g. on the command
+// line.
+func parseAddressString(s string) (address, error) {
+	traversal, diags := hclsyntax.ParseTraversalAbs([]byte(s), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return address{}, fmt.Errorf("invalid address %q: %s", s, diags)
+	}
+	return parseTraversal(traversal)
+}
+
+func parseTraversal(traversal hcl.Traversal) (address, error) {
+	var addr address
+	i := 0
+	for i < len(traversal) {
+		name, ok := traverserName(traversal[i])
+		if !ok || name != "module" {
+			break
+		}
+		if i+1 >= len(traversal) {
+			return address{}, fmt.Errorf("module name is missing after %q", "module")
+		}
+		moduleName, ok := traverserName(traversal[i+1])
+		if !ok {
+			return address{}, fmt.Errorf("module name is missing after %q", "module")
+		}
+		step := moduleStep{name: moduleName}
+		i += 2
+		if key, ok, err := traverserKey(traversal, i); err != nil {
+			return address{}, err
+		} else if ok {
+			step.key = key
+			i++
+		}
+		addr.modules = append(addr.modules, step)
+	}
+	if i == len(traversal) {
+		if len(addr.modules) == 0 {
+			return address{}, fmt.Errorf("empty address")
+		}
+		return addr, nil
+	}
+
+	resource := &resourceAddress{mode: "managed"}
+	if name, _ := traverserName(traversal[i]); name == "data" {
+		resource.mode = "data"
+		i++
+	}
+	if i+1 >= len(traversal) {
+		return address{}, fmt.Errorf("resource address must have a type and a name")
+	}
+	var ok bool
+	if resource.typ, ok = traverserName(traversal[i]); !ok {
+		return address{}, fmt.Errorf("resource address must have a type and a name")
+	}
+	if resource.name, ok = traverserName(traversal[i+1]); !ok {
+		return address{}, fmt.Errorf("resource address must have a type and a name")
+	}
+	i += 2
+	if key, ok, err := traverserKey(traversal, i); err != nil {
+		return address{}, err
+	} else if ok {
+		resource.key = key
+		i++
+	}
+	if i != len(traversal) {
+		return address{}, fmt.Errorf("unexpected %s after resource %s.%s", describeTraverser(traversal[i]), resource.typ, resource.name)
+	}
+	addr.resource = resource
+	return addr, nil
+}
+
+func traverserName(t hcl.Traverser) (string, bool) {
+	switch t := t.(type) {
+	case hcl.TraverseRoot:
+		return t.Name, true
+	case hcl.TraverseAttr:
+		return t.Name, true
+	}
+	return "", false
+}
+
+// traverserKey returns the instance key at traversal[i], if there is one.
+func traverserKey(traversal hcl.Traversal, i int) (*instanceKey, bool, error) {
+	if i >= len(traversal) {
+		return nil, false, nil
+	}
+	index, ok := traversal[i].(hcl.TraverseIndex)
+	if !ok {
+		return nil, false, nil
+	}
+	key, err := instanceKeyFromValue(index.Key)
+	if err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+func instanceKeyFromValue(v cty.Value) (*instanceKey, error) {
+	if v.IsNull() || !v.IsKnown() {
+		return nil, fmt.Errorf("instance key must be a known string or number")
+	}
+	switch v.Type() {
+	case cty.String:
+		return &instanceKey{str: v.AsString()}, nil
+	case cty.Number:
+		bf := v.AsBigFloat()
+		if !bf.IsInt() {
+			return nil, fmt.Errorf("instance key %s is not a whole number", bf.Text('f', -1))
+		}
+		n, accuracy := bf.Int64()
+		if accuracy != big.Exact {
+			return nil, fmt.Errorf("instance key %s is out of range", bf.Text('f', -1))
+		}
+		return &instanceKey{num: int(n), isNum: true}, nil
+	}
+	return nil, fmt.Errorf("instance key must be a string or number, got %s", v.Type().FriendlyName())
+}
+
+func describeTraverser(t hcl.Traverser) string {
+	switch t := t.(type) {
+	case hcl.TraverseAttr:
+		return strconv.Quote("." + t.Name)
+	case hcl.TraverseIndex:
+		return "index"
+	case hcl.TraverseSplat:
+		return "splat"
+	}
+	return fmt.Sprintf("%T", t)
+}
@@ -0,0 +1,109 @@
+package tfclean
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+func TestParseAddressString(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    address
+		wantErr bool
+	}{
+		{
+			input: "aws_instance.web",
+			want:  address{resource: &resourceAddress{mode: "managed", typ: "aws_instance", name: "web"}},
+		},
+		{
+			input: "aws_instance.web[0]",
+			want:  address{resource: &resourceAddress{mode: "managed", typ: "aws_instance", name: "web", key: &instanceKey{num: 0, isNum: true}}},
+		},
+		{
+			input: "data.aws_ami.this",
+			want:  address{resource: &resourceAddress{mode: "data", typ: "aws_ami", name: "this"}},
+		},
+		{
+			input: `module.app["a.b"]`,
+			want:  address{modules: []moduleStep{{name: "app", key: &instanceKey{str: "a.b"}}}},
+		},
+		{
+			input: `module.platform.module.network["x"].aws_vpc.this`,
+			want: address{
+				modules:  []moduleStep{{name: "platform"}, {name: "network", key: &instanceKey{str: "x"}}},
+				resource: &resourceAddress{mode: "managed", typ: "aws_vpc", name: "this"},
+			},
+		},
+		{
+			input: `module.foo["hoge"].bar.baz`,
+			want: address{
+				modules:  []moduleStep{{name: "foo", key: &instanceKey{str: "hoge"}}},
+				resource: &resourceAddress{mode: "managed", typ: "bar", name: "baz"},
+			},
+		},
+		{input: "module.foo.hoge", wantErr: true},
+		{input: "aws_instance", wantErr: true},
+		{input: "aws_instance.web.id", wantErr: true},
+		{input: "aws_instance.web[0.5]", wantErr: true},
+		{input: "module", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseAddressString(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAddressString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAddressString() = %#v, want %#v", got, tt.want)
+			}
+			if got.String() != tt.input {
+				t.Errorf("String() = %q, want %q", got.String(), tt.input)
+			}
+		})
+	}
+}
+
+// TestApp_applyAllDeletions_moduleKeyWithDot covers a module key containing a
+// dot, which the string-splitting heuristics mistook for a resource address.
+func TestApp_applyAllDeletions_moduleKeyWithDot(t *testing.T) {
+	data := []byte(`
+moved {
+  from = module.app["a.b"]
+  to   = module.app["c.d"]
+}
+`)
+	stateJSON := `
+{
+  "version": 4,
+  "resources": [
+    {
+      "module": "module.app[\"c.d\"]",
+      "mode": "managed",
+      "type": "time_static",
+      "name": "this",
+      "instances": [{"attributes": {"id": "x"}}]
+    }
+  ]
+}
+`
+	state, err := tfstate.Read(t.Context(), strings.NewReader(stateJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{hclParser: hclparse.NewParser(), CLI: &CLI{}}
+	got, err := app.applyAllDeletions(data, []*tfstate.TFState{state})
+	if err != nil {
+		t.Fatalf("applyAllDeletions() error = %v", err)
+	}
+	if want := "\n"; string(got) != want {
+		t.Errorf("applyAllDeletions() got = %q, want %q", got, want)
+	}
+}
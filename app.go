@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/fujiwara/tfstate-lookup/tfstate"
@@ -15,92 +18,270 @@ import (
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 )
 
 type App struct {
 	hclParser *hclparse.Parser
 	CLI       *CLI
+	stdout    io.Writer
+	// stdin is read by --tfstate -.
+	stdin io.Reader
+	// locals caches the locals of the configuration directory, used to
+	// evaluate for_each of import blocks.
+	locals map[string]hcl.Expression
+	// httpClient is used for backends reached over HTTP. nil means the
+	// default client.
+	httpClient *http.Client
+	// plan is the saved plan given with --plan. When set, blocks are
+	// decided from it instead of from states.
+	plan *savedPlan
 }
 
 func New(cli *CLI) *App {
 	return &App{
 		hclParser: hclparse.NewParser(),
 		CLI:       cli,
+		stdout:    os.Stdout,
+		stdin:     os.Stdin,
 	}
 }
 
 func (app *App) Run(ctx context.Context) error {
+	// --dry-run and --check print to stdout too, which would leave a report
+	// there unparseable.
+	if app.CLI.Report != "" && app.CLI.ReportFile == "" && (app.CLI.DryRun || app.CLI.Check) {
+		opt := "--dry-run"
+		if !app.CLI.DryRun {
+			opt = "--check"
+		}
+		return fmt.Errorf("--report without --report-file cannot be combined with %s, which also writes to stdout", opt)
+	}
+	states, sources, err := app.loadStates(ctx)
+	if err != nil {
+		return err
+	}
+
+	results, changed, err := app.cleanDir(states)
+	if app.CLI.Report != "" {
+		// The report is written even when cleaning stopped on an error, so the
+		// block that caused it shows up with an "error" decision.
+		if reportErr := app.writeReport(sources, results); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if app.CLI.Check && changed > 0 {
+		return fmt.Errorf("%w in %d file(s)", ErrCleanupNeeded, changed)
+	}
+	return nil
+}
+
+// loadStates reads the states blocks are checked against, along with a name
+// for each one. It returns no states only when --all is given, or when --plan
+// is given, in which case the plan is loaded instead and named.
+func (app *App) loadStates(ctx context.Context) ([]*tfstate.TFState, []string, error) {
 	var states []*tfstate.TFState
+	var sources []string
 
-	if len(app.CLI.Tfstate) > 0 {
+	switch {
+	case app.CLI.All:
+		// Remove-all mode is only ever entered explicitly. Running with zero
+		// states makes appliedInAll treat every block as applied.
+		if len(app.CLI.Tfstate) > 0 {
+			return nil, nil, fmt.Errorf("--all cannot be combined with --tfstate")
+		}
+		if len(app.CLI.StateCommand) > 0 {
+			return nil, nil, fmt.Errorf("--all cannot be combined with --state-command")
+		}
+		if app.CLI.Plan != "" {
+			return nil, nil, fmt.Errorf("--all cannot be combined with --plan")
+		}
+		if opts := app.CLI.detectionOptions(); len(opts) > 0 {
+			return nil, nil, fmt.Errorf("--all cannot be combined with %s", strings.Join(opts, ", "))
+		}
+	case app.CLI.Plan != "":
+		// The plan replaces states as the decision source; mixing the two
+		// would leave it unclear which one a decision came from.
+		opts := app.CLI.detectionOptions()
+		if len(app.CLI.Tfstate) > 0 {
+			opts = append(opts, "--tfstate")
+		}
+		if len(app.CLI.StateCommand) > 0 {
+			opts = append(opts, "--state-command")
+		}
+		if len(opts) > 0 {
+			return nil, nil, fmt.Errorf("--plan cannot be combined with %s", strings.Join(opts, ", "))
+		}
+		plan, err := readPlan(app.CLI.Plan)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read plan from %s: %w", app.CLI.Plan, err)
+		}
+		app.plan = plan
+		sources = append(sources, app.CLI.Plan)
+	case len(app.CLI.Tfstate) > 0 || len(app.CLI.StateCommand) > 0:
+		if opts := app.CLI.detectionOptions(); len(opts) > 0 {
+			return nil, nil, fmt.Errorf("%s only applies to the auto-detected backend and cannot be combined with --tfstate or --state-command", strings.Join(opts, ", "))
+		}
 		// States given explicitly. A read failure is a hard error: silently
 		// dropping a state would shrink the set we require agreement across and
 		// could delete a block that is still unapplied in the dropped state.
-		for _, url := range app.CLI.Tfstate {
-			state, err := tfstate.ReadURL(ctx, url)
+		// For the same reason a pattern that matches nothing is an error.
+		var explicit []stateSource
+		stdinUsed := false
+		for _, value := range app.CLI.Tfstate {
+			if value == "-" {
+				if stdinUsed {
+					return nil, nil, fmt.Errorf("--tfstate - can only be given once")
+				}
+				stdinUsed = true
+				explicit = append(explicit, readerSource("stdin", app.stdin))
+				continue
+			}
+			expanded, err := app.tfstateSources(ctx, value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not expand --tfstate %s: %w", value, err)
+			}
+			explicit = append(explicit, expanded...)
+		}
+		for _, command := range app.CLI.StateCommand {
+			explicit = append(explicit, commandSource(command))
+		}
+		for _, source := range explicit {
+			state, err := source.read(ctx)
 			if err != nil {
-				return fmt.Errorf("could not read state from %s: %w", url, err)
+				return nil, nil, fmt.Errorf("could not read state from %s: %w", source.name, err)
 			}
 			states = append(states, state)
+			sources = append(sources, source.name)
 		}
-	} else {
-		detectedURL, err := app.detectBackendFromConfig()
+	default:
+		// Auto-detected state follows the same rule: without a state every block
+		// would be removed, so failing to find or read it is a hard error too.
+		detected, err := app.detectBackendFromConfig(ctx)
 		if err != nil {
-			log.Printf("Warning: Could not auto-detect backend configuration: %v", err)
-			log.Printf("Continuing without state file. Use --tfstate flag to specify state location manually.")
-		} else if detectedURL != "" {
-			log.Printf("Auto-detected state location: %s", detectedURL)
-			state, err := tfstate.ReadURL(ctx, detectedURL)
+			return nil, nil, fmt.Errorf("could not auto-detect backend configuration: %w (use --tfstate to specify the state location, or --all to remove every block)", err)
+		}
+		for _, source := range detected {
+			log.Printf("Auto-detected state location: %s", source.name)
+			state, err := source.read(ctx)
 			if err != nil {
-				log.Printf("Warning: Could not read state from auto-detected location: %v", err)
-				log.Printf("Continuing without state file.")
-			} else {
-				states = append(states, state)
+				return nil, nil, fmt.Errorf("could not read state from auto-detected location %s: %w", source.name, err)
 			}
+			states = append(states, state)
+			sources = append(sources, source.name)
 		}
 	}
+	return states, sources, nil
+}
 
+// cleanDir cleans every .tf file in the target directory. It returns the
+// decisions made for all blocks seen and the number of files that changed.
+func (app *App) cleanDir(states []*tfstate.TFState) ([]blockResult, int, error) {
 	files, err := os.ReadDir(app.CLI.Dir)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
+	var results []blockResult
+	changed := 0
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
 		if filepath.Ext(file.Name()) == ".tf" {
 			path := filepath.Join(app.CLI.Dir, file.Name())
-			err := app.processFile(path, states)
+			change, err := app.planFile(path, states)
+			if change != nil {
+				results = append(results, change.blocks...)
+			}
 			if err != nil {
-				return err
+				return results, changed, err
+			}
+			if change.changed() {
+				changed++
+			}
+			if err := app.applyChange(change); err != nil {
+				return results, changed, err
 			}
 		}
 	}
-	return nil
+	return results, changed, nil
+}
+
+// fileChange is the outcome of cleaning a single file. It is computed without
+// touching the disk so --dry-run and --check can report it instead of applying
+// it.
+type fileChange struct {
+	path     string
+	original []byte
+	data     []byte
+	// remove is set when cleaning leaves the file without any blocks or
+	// attributes, in which case the file is deleted rather than rewritten.
+	remove bool
+	blocks []blockResult
+}
+
+func (c *fileChange) changed() bool {
+	return c.remove || !bytes.Equal(c.data, c.original)
 }
 
 func (app *App) processFile(path string, states []*tfstate.TFState) error {
-	original, err := os.ReadFile(path)
+	change, err := app.planFile(path, states)
 	if err != nil {
 		return err
 	}
+	return app.applyChange(change)
+}
 
-	data, err := app.applyAllDeletions(original, states)
+// planFile computes the change for the file at path. If a block could not be
+// evaluated, the returned error is accompanied by a change whose blocks record
+// the decisions made so far; that change must not be applied.
+func (app *App) planFile(path string, states []*tfstate.TFState) (*fileChange, error) {
+	original, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	data, blocks, err := app.cleanData(path, original, states)
+	if err != nil {
+		return &fileChange{path: path, blocks: blocks}, err
+	}
+
+	change := &fileChange{path: path, original: original, data: data, blocks: blocks}
 	if !bytes.Equal(data, original) {
-		empty, err := app.isEmptyConfig(data)
+		change.remove, err = app.isEmptyConfig(data)
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+	return change, nil
+}
+
+// applyChange writes change to disk, or only reports it in --dry-run and
+// --check mode.
+func (app *App) applyChange(change *fileChange) error {
+	if app.CLI.DryRun {
+		data := change.data
+		if change.remove {
+			data = nil
 		}
-		if empty {
-			return os.Remove(path)
+		_, err := io.WriteString(app.stdout, unifiedDiff(change.path, change.original, data, change.remove))
+		return err
+	}
+	if app.CLI.Check {
+		if change.changed() {
+			_, err := fmt.Fprintln(app.stdout, change.path)
+			return err
 		}
+		return nil
 	}
-	return os.WriteFile(path, data, 0644)
+	if change.remove {
+		return os.Remove(change.path)
+	}
+	return os.WriteFile(change.path, change.data, 0644)
 }
 
 func (app *App) isEmptyConfig(data []byte) (bool, error) {
@@ -151,89 +332,204 @@ func (app *App) collectIgnoreAnnotations(data []byte) (bool, map[int]bool, error
 	return fileIgnored, ignoredLines, nil
 }
 
-// appliedInAll reports whether check returns true for every state. With no
-// states it returns true so callers fall back to removing the block
-// unconditionally (the "remove all" / forced mode). When several states are
-// given, a block counts as applied only if it is applied in all of them, so a
-// block still pending in any one state is preserved.
-func (app *App) appliedInAll(states []*tfstate.TFState, check func(*tfstate.TFState) (bool, error)) (bool, error) {
+// appliedInAll reports whether check returns true for every state, together
+// with the result for each state in the order given. Every state is checked,
+// even after one reports the block as pending, so the per-state results are
+// complete. With no states it returns true so the block is removed
+// unconditionally; Run only passes no states when --all is given. When several
+// states are given, a block counts as applied only if it is applied in all of
+// them, so a block still pending in any one state is preserved.
+func (app *App) appliedInAll(states []*tfstate.TFState, check func(*tfstate.TFState) (bool, error)) (bool, []bool, error) {
+	all := true
+	results := make([]bool, 0, len(states))
 	for _, state := range states {
 		applied, err := check(state)
 		if err != nil {
-			return false, err
-		}
-		if !applied {
-			return false, nil
+			return false, results, err
 		}
+		results = append(results, applied)
+		all = all && applied
 	}
-	return true, nil
+	return all, results, nil
 }
 
-func (app *App) collectDeletionRanges(body *hclsyntax.Body, states []*tfstate.TFState, ignoredLines map[int]bool) ([]hcl.Range, error) {
-	ranges := make([]hcl.Range, 0, len(body.Blocks))
+// collectBlockResults decides, for every moved, import and removed block in
+// body, whether it is removed, kept or ignored. Evaluation stops at the first
+// block whose state check fails; that block is recorded with decisionError.
+// When states are consulted, a block whose from/to cannot be resolved is also
+// recorded with decisionError and kept, unless --strict turns it into a
+// failure of the run.
+func (app *App) collectBlockResults(body *hclsyntax.Body, states []*tfstate.TFState, fileIgnored bool, ignoredLines map[int]bool) ([]blockResult, error) {
+	results := make([]blockResult, 0, len(body.Blocks))
 	for _, block := range body.Blocks {
-		if ignoredLines[block.Range().Start.Line-1] {
+		if !isCleanableBlock(block) {
 			continue
 		}
-		var applied bool
-		var err error
+		result := blockResult{rng: block.Range(), typ: block.Type}
+		from, to, addrErr := app.blockAddresses(block, body)
+		result.from, result.to = from.String(), addressList(to)
+		if fileIgnored || ignoredLines[block.Range().Start.Line-1] {
+			result.decision = decisionIgnored
+			results = append(results, result)
+			continue
+		}
+		// With --all no state is consulted, so the addresses do not matter and
+		// the block is removed like any other.
+		if addrErr != nil && (len(states) > 0 || app.plan != nil) {
+			result.decision = decisionError
+			result.err = addrErr
+			results = append(results, result)
+			if app.CLI != nil && app.CLI.Strict {
+				return results, addrErr
+			}
+			log.Printf("Warning: %v; keeping the block", addrErr)
+			continue
+		}
+
+		var check func(*tfstate.TFState) (bool, error)
 		switch block.Type {
 		case "import":
-			to, _ := app.getValueFromAttribute(block.Body.Attributes["to"])
-			applied, err = app.appliedInAll(states, func(state *tfstate.TFState) (bool, error) {
+			check = func(state *tfstate.TFState) (bool, error) {
 				return app.movedImportIsApplied(state, to)
-			})
+			}
 		case "moved":
-			from, _ := app.getValueFromAttribute(block.Body.Attributes["from"])
-			to, _ := app.getValueFromAttribute(block.Body.Attributes["to"])
-			applied, err = app.appliedInAll(states, func(state *tfstate.TFState) (bool, error) {
-				return app.movedBlockIsApplied(state, from, to)
-			})
+			check = func(state *tfstate.TFState) (bool, error) {
+				return app.movedBlockIsApplied(state, from, to[0])
+			}
 		case "removed":
-			from, _ := app.getValueFromAttribute(block.Body.Attributes["from"])
-			applied, err = app.appliedInAll(states, func(state *tfstate.TFState) (bool, error) {
+			check = func(state *tfstate.TFState) (bool, error) {
 				return app.removedBlockIsApplied(state, from)
-			})
+			}
+		}
+		var applied bool
+		var perState []bool
+		var err error
+		if app.plan != nil {
+			applied = app.planEvidence(app.plan, block.Type, from, to).applied
+			perState = []bool{applied}
+		} else {
+			applied, perState, err = app.appliedInAll(states, check)
+		}
+		result.applied = perState
+		switch {
+		case err != nil:
+			result.decision = decisionError
+			result.err = err
+		case applied:
+			result.decision = decisionRemoved
 		default:
-			continue
+			result.decision = decisionKept
 		}
+		results = append(results, result)
 		if err != nil {
-			return nil, err
+			return results, err
 		}
-		if applied {
-			ranges = append(ranges, block.Range())
+	}
+	return results, nil
+}
+
+func isCleanableBlock(block *hclsyntax.Block) bool {
+	switch block.Type {
+	case "import", "moved", "removed":
+		return true
+	}
+	return false
+}
+
+// blockAddresses resolves the addresses of a moved, import or removed block:
+// from and to for moved, to for import and from for removed. An import block
+// with for_each has one to address per element; the other blocks have at
+// most one. body is the file containing the block. The error names the file
+// and line of the offending block or attribute.
+func (app *App) blockAddresses(block *hclsyntax.Block, body *hclsyntax.Body) (address, []address, error) {
+	var from, to address
+	var err error
+	switch block.Type {
+	case "import":
+		targets, err := app.importTargets(block, body)
+		return from, targets, err
+	case "moved":
+		from, err = app.blockAddress(block, "from", nil)
+		if err == nil {
+			to, err = app.blockAddress(block, "to", nil)
 		}
+		return from, []address{to}, err
+	case "removed":
+		from, err = app.blockAddress(block, "from", nil)
 	}
-	return ranges, nil
+	return from, nil, err
+}
+
+// blockAddress resolves the address in the attribute name of block. Instance
+// keys are evaluated in ctx, which is nil outside of for_each.
+func (app *App) blockAddress(block *hclsyntax.Block, name string, ctx *hcl.EvalContext) (address, error) {
+	attr, ok := block.Body.Attributes[name]
+	if !ok {
+		rng := block.Range()
+		return address{}, fmt.Errorf("%s:%d: %s block has no %s attribute", rng.Filename, rng.Start.Line, block.Type, name)
+	}
+	addr, err := evalAddress(attr.Expr, ctx)
+	if err != nil {
+		rng := attr.Range()
+		return address{}, fmt.Errorf("%s:%d: cannot resolve %s of %s block: %w", rng.Filename, rng.Start.Line, name, block.Type, err)
+	}
+	return addr, nil
+}
+
+// addressList renders addresses for reports, separated by commas.
+func addressList(addrs []address) string {
+	var parts []string
+	for _, addr := range addrs {
+		if s := addr.String(); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
 }
 
 func (app *App) applyAllDeletions(data []byte, states []*tfstate.TFState) ([]byte, error) {
+	data, _, err := app.cleanData("memory.tf", data, states)
+	return data, err
+}
+
+// cleanData removes the applied blocks from data and returns the result along
+// with the decision made for each block. filename is only used to label the
+// decisions.
+func (app *App) cleanData(filename string, data []byte, states []*tfstate.TFState) ([]byte, []blockResult, error) {
 	if len(data) == 0 {
-		return data, nil
+		return data, nil, nil
 	}
 	fileIgnored, ignoredLines, err := app.collectIgnoreAnnotations(data)
 	if err != nil {
-		return nil, err
-	}
-	if fileIgnored {
-		return data, nil
+		return nil, nil, err
 	}
 	// Create a new parser each time to avoid the influence of previous parse results
 	parser := hclparse.NewParser()
-	hclFile, diags := parser.ParseHCL(data, "memory.tf")
+	hclFile, diags := parser.ParseHCL(data, filename)
 	if diags.HasErrors() {
-		return nil, fmt.Errorf("error parsing HCL: %s", diags)
+		if fileIgnored {
+			// The file is left untouched anyway; parsing is only needed to
+			// report its blocks as ignored.
+			return data, nil, nil
+		}
+		return nil, nil, fmt.Errorf("error parsing HCL: %s", diags)
 	}
 	body, ok := hclFile.Body.(*hclsyntax.Body)
 	if !ok {
-		return data, nil
+		return data, nil, nil
 	}
-	ranges, err := app.collectDeletionRanges(body, states, ignoredLines)
+	results, err := app.collectBlockResults(body, states, fileIgnored, ignoredLines)
 	if err != nil {
-		return nil, err
+		return nil, results, err
+	}
+	var ranges []hcl.Range
+	for _, result := range results {
+		if result.decision == decisionRemoved {
+			ranges = append(ranges, result.rng)
+		}
 	}
 	if len(ranges) == 0 {
-		return data, nil
+		return data, results, nil
 	}
 	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start.Byte > ranges[j].Start.Byte })
 	for _, r := range ranges {
@@ -255,7 +551,7 @@ func (app *App) applyAllDeletions(data []byte, states []*tfstate.TFState) ([]byt
 		// Normalize consecutive newlines around the deletion range (collapse 3 or more consecutive newlines into 2)
 		data = app.normalizeNewlinesAround(data, start)
 	}
-	return data, nil
+	return data, results, nil
 }
 
 func (app *App) normalizeNewlinesAround(data []byte, pos int) []byte {
@@ -314,182 +610,209 @@ func (app *App) normalizeNewlinesAround(data []byte, pos int) []byte {
 	return data
 }
 
-func (app *App) movedImportIsApplied(state *tfstate.TFState, to string) (bool, error) {
-	toAttrs, err := state.Lookup(to)
-	if err != nil {
-		return false, err
-	}
+// blockEvidence is what a single state says about a block: the addresses that
+// were looked up and the rule that classified the block as applied or pending.
+type blockEvidence struct {
+	applied bool
+	lookups []addressLookup
+	rule    string
+}
 
-	if toAttrs.String() != "null" {
-		return true, nil
-	}
-	return false, nil
+// addressLookup records the result of looking up one address of a block.
+// matches lists the state addresses found under a module address, or the
+// instances found for a resource address. With planned set, the address was
+// looked up in a plan and matches describes the planned changes instead.
+type addressLookup struct {
+	role    string
+	address string
+	module  bool
+	planned bool
+	found   bool
+	matches []string
 }
 
-func (app *App) movedBlockIsApplied(state *tfstate.TFState, from string, to string) (bool, error) {
-	if strings.HasPrefix(from, "module.") && strings.HasPrefix(to, "module.") && len(strings.Split(from, ".")) == 2 && len(strings.Split(to, ".")) == 2 {
-		// from and to is module
-		names, err := state.List()
+// lookupResource collects the state addresses of the instances of the
+// resource addr refers to. An address with an instance key matches only that
+// instance; match decides whether an address without one also covers keyed
+// instances.
+func (app *App) lookupResource(state *tfstate.TFState, role string, addr address, match keyMatch) (addressLookup, error) {
+	names, err := state.List()
+	if err != nil {
+		return addressLookup{}, err
+	}
+	lookup := addressLookup{role: role, address: addr.String()}
+	for _, name := range names {
+		stateAddr, err := parseAddressString(name)
 		if err != nil {
-			return false, err
-		}
-		existsFrom := false
-		for _, name := range names {
-			if strings.HasPrefix(name, from+".") {
-				existsFrom = true
-				break
-			}
-		}
-		existsTo := false
-		for _, name := range names {
-			if strings.HasPrefix(name, to+".") {
-				existsTo = true
-				break
-			}
-		}
-		if !existsFrom && existsTo {
-			return true, nil
+			// Not a resource address, e.g. an output.
+			continue
 		}
-		if !existsFrom && !existsTo {
-			return true, nil
+		if addr.matchesResource(stateAddr, match) {
+			lookup.matches = append(lookup.matches, name)
 		}
-		return false, nil
-	} else {
-		// from and to is resource
-		fromAttrs, err := state.Lookup(from)
+	}
+	lookup.found = len(lookup.matches) > 0
+	return lookup, nil
+}
+
+// lookupModule collects the state addresses under the module path of addr,
+// at any depth. match decides whether module steps written without an
+// instance key also cover keyed instances.
+func (app *App) lookupModule(state *tfstate.TFState, role string, addr address, match keyMatch) (addressLookup, error) {
+	names, err := state.List()
+	if err != nil {
+		return addressLookup{}, err
+	}
+	lookup := addressLookup{role: role, address: addr.String(), module: true}
+	for _, name := range names {
+		stateAddr, err := parseAddressString(name)
 		if err != nil {
-			return false, err
+			// Not a resource address, e.g. an output.
+			continue
 		}
-		toAttrs, err := state.Lookup(to)
+		if addr.containsModuleOf(stateAddr, match) {
+			lookup.matches = append(lookup.matches, name)
+		}
+	}
+	lookup.found = len(lookup.matches) > 0
+	return lookup, nil
+}
+
+func (app *App) movedImportIsApplied(state *tfstate.TFState, targets []address) (bool, error) {
+	evidence, err := app.importEvidence(state, targets)
+	return evidence.applied, err
+}
+
+// importEvidence checks the targets of an import block, one per for_each
+// element. The import counts as applied once every target is in state.
+func (app *App) importEvidence(state *tfstate.TFState, targets []address) (blockEvidence, error) {
+	var evidence blockEvidence
+	missing := 0
+	for _, to := range targets {
+		// Any instance of the resource shows that the import has been applied.
+		toLookup, err := app.lookupResource(state, "to", to, anyKeyLastStep)
 		if err != nil {
-			return false, err
+			return blockEvidence{}, err
 		}
-		if fromAttrs.String() == "null" && toAttrs.String() != "null" {
-			return true, nil
+		evidence.lookups = append(evidence.lookups, toLookup)
+		if !toLookup.found {
+			missing++
 		}
-		return false, nil
 	}
+	evidence.applied = missing == 0
+	switch {
+	case len(targets) == 1 && evidence.applied:
+		evidence.rule = "to is in state, so the import has been applied"
+	case len(targets) == 1:
+		evidence.rule = "to is not in state yet, so the import is pending"
+	case evidence.applied:
+		evidence.rule = fmt.Sprintf("all %d for_each targets are in state, so the import has been applied", len(targets))
+	default:
+		evidence.rule = fmt.Sprintf("%d of %d for_each targets are not in state yet, so the import is pending", missing, len(targets))
+	}
+	return evidence, nil
+}
+
+func (app *App) movedBlockIsApplied(state *tfstate.TFState, from address, to address) (bool, error) {
+	evidence, err := app.movedEvidence(state, from, to)
+	return evidence.applied, err
 }
 
-func (app *App) removedBlockIsApplied(state *tfstate.TFState, from string) (bool, error) {
-	if strings.HasPrefix(from, "module.") && len(strings.Split(from, ".")) == 2 {
-		names, err := state.List()
+func (app *App) movedEvidence(state *tfstate.TFState, from address, to address) (blockEvidence, error) {
+	if from.isModule() && to.isModule() {
+		// from and to is module. Without keys on either side the block moves
+		// the whole module call, so every instance of it counts; otherwise a
+		// keyless side refers to the single instance without a key.
+		match := exactKeys
+		if from.modules[len(from.modules)-1].key == nil && to.modules[len(to.modules)-1].key == nil {
+			match = anyKeyLastStep
+		}
+		fromLookup, err := app.lookupModule(state, "from", from, match)
 		if err != nil {
-			return false, err
+			return blockEvidence{}, err
 		}
-		existsFrom := false
-		for _, name := range names {
-			if strings.HasPrefix(name, from+".") {
-				existsFrom = true
-				break
-			}
+		toLookup, err := app.lookupModule(state, "to", to, match)
+		if err != nil {
+			return blockEvidence{}, err
 		}
-		if !existsFrom {
-			return true, nil
+		evidence := blockEvidence{lookups: []addressLookup{fromLookup, toLookup}}
+		switch {
+		case !fromLookup.found && toLookup.found:
+			evidence.applied = true
+			evidence.rule = "no resources remain under from and some exist under to, so the move has been applied"
+		case !fromLookup.found && !toLookup.found:
+			evidence.applied = true
+			evidence.rule = "neither module has resources in state, so there is nothing left to move"
+		default:
+			evidence.rule = "resources remain under from, so the move is pending"
 		}
-		return false, nil
+		return evidence, nil
 	} else {
-		// resource
-		fromAttrs, err := state.Lookup(from)
+		// from and to is resource. As with modules, without keys on either
+		// side the whole resource moves, with all of its instances.
+		match := exactKeys
+		if from.resource != nil && to.resource != nil && from.resource.key == nil && to.resource.key == nil {
+			match = anyKeyLastStep
+		}
+		fromLookup, err := app.lookupResource(state, "from", from, match)
 		if err != nil {
-			return false, err
+			return blockEvidence{}, err
 		}
-		if fromAttrs.String() == "null" {
-			return true, nil
+		toLookup, err := app.lookupResource(state, "to", to, match)
+		if err != nil {
+			return blockEvidence{}, err
 		}
-		return false, nil
+		evidence := blockEvidence{lookups: []addressLookup{fromLookup, toLookup}}
+		switch {
+		case !fromLookup.found && toLookup.found:
+			evidence.applied = true
+			evidence.rule = "from is gone and to is in state, so the move has been applied"
+		case fromLookup.found:
+			evidence.rule = "from is still in state, so the move is pending"
+		default:
+			evidence.rule = "neither from nor to is in state, so the move is treated as pending"
+		}
+		return evidence, nil
 	}
 }
 
-func (app *App) getValueFromAttribute(attr *hclsyntax.Attribute) (string, error) {
-	switch attr.Expr.(type) {
-	case *hclsyntax.TemplateExpr:
-		result := []string{}
-		for _, part := range attr.Expr.(*hclsyntax.TemplateExpr).Parts {
-			switch part.(type) {
-			case *hclsyntax.LiteralValueExpr:
-				result = append(result, part.(*hclsyntax.LiteralValueExpr).Val.AsString())
-			case *hclsyntax.ScopeTraversalExpr:
-				valueSlice := []string{"\"", "${"}
-				for _, traversals := range part.(*hclsyntax.ScopeTraversalExpr).Variables() {
-					tl := len(traversals)
-					for i, traversal := range traversals {
-						switch traversal.(type) {
-						case hcl.TraverseRoot:
-							valueSlice = append(valueSlice, traversal.(hcl.TraverseRoot).Name)
-							valueSlice = append(valueSlice, ".")
-							if i == tl-1 {
-								valueSlice = valueSlice[:len(valueSlice)-1]
-							}
-						case hcl.TraverseAttr:
-							valueSlice = append(valueSlice, traversal.(hcl.TraverseAttr).Name)
-							valueSlice = append(valueSlice, ".")
-							if i == tl-1 {
-								valueSlice = valueSlice[:len(valueSlice)-1]
-							}
-						}
-					}
-				}
-				valueSlice = append(valueSlice, "}")
-				result = append(result, strings.Join(valueSlice, ""))
-			default:
-				return "", fmt.Errorf("unexpected type: %T", part)
-			}
-		}
-		result = append(result, "\"")
-		return strings.Join(result, ""), nil
-	case *hclsyntax.ScopeTraversalExpr:
-		valueSlice := []string{}
-		for _, traversals := range attr.Expr.(*hclsyntax.ScopeTraversalExpr).Variables() {
-			tl := len(traversals)
-			for i, traversal := range traversals {
-				switch traversal.(type) {
-				case hcl.TraverseRoot:
-					valueSlice = append(valueSlice, traversal.(hcl.TraverseRoot).Name)
-					valueSlice = append(valueSlice, ".")
-					if i == tl-1 {
-						valueSlice = valueSlice[:len(valueSlice)-1]
-						return strings.Join(valueSlice, ""), nil
-					}
-				case hcl.TraverseAttr:
-					valueSlice = append(valueSlice, traversal.(hcl.TraverseAttr).Name)
-					valueSlice = append(valueSlice, ".")
-					if i == tl-1 {
-						valueSlice = valueSlice[:len(valueSlice)-1]
-						return strings.Join(valueSlice, ""), nil
-					}
-				case hcl.TraverseIndex:
-					valueSlice = valueSlice[:len(valueSlice)-1]
-					switch traversal.(hcl.TraverseIndex).Key.Type().FriendlyName() {
-					case "string":
-						valueSlice = append(valueSlice, fmt.Sprintf("[\"%s\"]", traversal.(hcl.TraverseIndex).Key.AsString()))
-						if i == tl-1 {
-							return strings.Join(valueSlice, ""), nil
-						}
-						valueSlice = append(valueSlice, ".")
-					case "number":
-						valueSlice = append(valueSlice, fmt.Sprintf("[%s]", traversal.(hcl.TraverseIndex).Key.AsBigFloat().String()))
-						if i == tl-1 {
-							return strings.Join(valueSlice, ""), nil
-						}
-						valueSlice = append(valueSlice, ".")
-					default:
-						return "", fmt.Errorf("unexpected type: %T", traversal.(hcl.TraverseIndex).Key.Type().FriendlyName())
-					}
-				}
-			}
-		}
-		return strings.Join(valueSlice, ""), nil
-	default:
-		return "", fmt.Errorf("unexpected type: %T", attr.Expr)
+func (app *App) removedBlockIsApplied(state *tfstate.TFState, from address) (bool, error) {
+	evidence, err := app.removedEvidence(state, from)
+	return evidence.applied, err
+}
+
+func (app *App) removedEvidence(state *tfstate.TFState, from address) (blockEvidence, error) {
+	var fromLookup addressLookup
+	var err error
+	if from.isModule() {
+		// A removed block refers to every instance of the module.
+		fromLookup, err = app.lookupModule(state, "from", from, anyKeyAllSteps)
+	} else {
+		// A removed block refers to every instance of the resource.
+		fromLookup, err = app.lookupResource(state, "from", from, anyKeyAllSteps)
+	}
+	if err != nil {
+		return blockEvidence{}, err
+	}
+	evidence := blockEvidence{lookups: []addressLookup{fromLookup}}
+	if fromLookup.found {
+		evidence.rule = "from is still in state, so the removal is pending"
+	} else {
+		evidence.applied = true
+		evidence.rule = "from is no longer in state, so the removal has been applied"
 	}
-	return "", nil
+	return evidence, nil
 }
 
-func (app *App) detectBackendFromConfig() (string, error) {
+// detectBackendFromConfig finds the backend or cloud block configured in the
+// .tf files of the target directory and returns the states it holds for the
+// current workspace, or for every workspace a cloud block selects by tags.
+// When the .tf files are not enough, it falls back to the backend recorded by
+// terraform init.
+func (app *App) detectBackendFromConfig(ctx context.Context) ([]stateSource, error) {
 	files, err := os.ReadDir(app.CLI.Dir)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	parser := hclparse.NewParser()
@@ -519,41 +842,240 @@ func (app *App) detectBackendFromConfig() (string, error) {
 
 	for _, terraformBlock := range terraformBlocks {
 		for _, block := range terraformBlock.Body.Blocks {
-			if block.Type == "backend" {
-				return app.buildStateURLFromBackend(block)
+			if block.Type != "backend" && block.Type != "cloud" {
+				continue
+			}
+			sources, err := app.configuredSources(ctx, block)
+			if err == nil || len(app.CLI.BackendConfig) > 0 || app.CLI.BackendConfigDir != "" {
+				return sources, err
 			}
+			// The .tf files may leave settings to terraform init. If the
+			// directory has been initialised with the same backend, use
+			// the settings init resolved.
+			initialized, initErr := app.initializedBackend()
+			if initErr != nil || initialized == nil || blockBackendType(initialized) != blockBackendType(block) {
+				return nil, err
+			}
+			return app.configuredSources(ctx, initialized)
 		}
 	}
 
-	return "", fmt.Errorf("no backend configuration found")
+	// Without a backend block in the .tf files, use the backend terraform
+	// init recorded, then the states Terraform keeps next to the
+	// configuration when there is no backend at all.
+	initialized, err := app.initializedBackend()
+	if err != nil {
+		return nil, err
+	}
+	if initialized != nil {
+		return app.configuredSources(ctx, initialized)
+	}
+	if opts := app.CLI.backendConfigOptions(); len(opts) > 0 {
+		return nil, fmt.Errorf("%s only applies to a backend block, and none is configured in %s", strings.Join(opts, ", "), app.CLI.Dir)
+	}
+	implicit := filepath.Join(app.CLI.Dir, defaultLocalStatePath)
+	for _, path := range []string{implicit, filepath.Join(app.CLI.Dir, defaultLocalWorkspaceDir)} {
+		if _, err := os.Stat(path); err == nil {
+			return app.localWorkspaceSources(implicit, defaultLocalWorkspaceDir)
+		}
+	}
+	return nil, fmt.Errorf("no backend configuration found and no terraform.tfstate in %s", app.CLI.Dir)
 }
 
-func (app *App) buildStateURLFromBackend(backendBlock *hclsyntax.Block) (string, error) {
-	if len(backendBlock.Labels) == 0 {
-		return "", fmt.Errorf("backend block has no type label")
+// configuredSources returns the states of a backend or cloud block, with the
+// --backend-config values merged into a backend block. A cloud block takes no
+// --backend-config values.
+func (app *App) configuredSources(ctx context.Context, block *hclsyntax.Block) ([]stateSource, error) {
+	if block.Type == "cloud" {
+		if app.CLI.AllWorkspaces {
+			return nil, fmt.Errorf("--all-workspaces is not supported for cloud blocks")
+		}
+		if opts := app.CLI.backendConfigOptions(); len(opts) > 0 {
+			return nil, fmt.Errorf("%s only applies to a backend block, not to a cloud block", strings.Join(opts, ", "))
+		}
+		cfg, err := app.buildCloudConfig(block)
+		if err != nil {
+			return nil, err
+		}
+		return app.tfeSources(ctx, cfg)
 	}
-
-	backendType := backendBlock.Labels[0]
-
-	if backendType != "s3" {
-		return "", fmt.Errorf("unsupported backend type: %s (only S3 backend is supported for auto-detection)", backendType)
+	sets, err := app.backendConfigSets()
+	if err != nil {
+		return nil, err
+	}
+	if sets == nil {
+		return app.backendSources(ctx, block)
+	}
+	var sources []stateSource
+	for _, overrides := range sets {
+		merged, err := mergeBackendConfig(block, overrides)
+		if err != nil {
+			return nil, err
+		}
+		found, err := app.backendSources(ctx, merged)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, found...)
 	}
+	return sources, nil
+}
 
-	return app.buildS3URL(backendBlock)
+// backendSources returns the states of a backend block.
+func (app *App) backendSources(ctx context.Context, backendBlock *hclsyntax.Block) ([]stateSource, error) {
+	var backendType string
+	if len(backendBlock.Labels) > 0 {
+		backendType = backendBlock.Labels[0]
+	}
+	if app.CLI.AllWorkspaces && backendType != "s3" && backendType != "local" && backendType != "pg" {
+		return nil, fmt.Errorf("--all-workspaces is not supported for the %s backend", backendType)
+	}
+	switch backendType {
+	case "s3":
+		cfg, err := app.buildS3Config(backendBlock)
+		if err != nil {
+			return nil, err
+		}
+		if app.CLI.AllWorkspaces {
+			return app.s3WorkspaceSources(ctx, cfg)
+		}
+		key := cfg.workspaceKey(app.currentWorkspace())
+		return []stateSource{app.s3Source(fmt.Sprintf("s3://%s/%s", cfg.bucket, key), cfg, key)}, nil
+	case "azurerm":
+		config, err := app.buildAzureRMConfig(backendBlock)
+		if err != nil {
+			return nil, err
+		}
+		ws := app.currentWorkspace()
+		key := config["key"].(string)
+		if ws != "default" {
+			key += "env:" + ws
+		}
+		name := fmt.Sprintf("azurerm://%s/%s/%s", config["storage_account_name"], config["container_name"], key)
+		return []stateSource{backendSource(name, "azurerm", config, ws)}, nil
+	case "remote":
+		cfg, err := app.buildRemoteConfig(backendBlock)
+		if err != nil {
+			return nil, err
+		}
+		return app.tfeSources(ctx, cfg)
+	case "http":
+		cfg, err := app.buildHTTPConfig(backendBlock)
+		if err != nil {
+			return nil, err
+		}
+		return []stateSource{app.httpSource(cfg)}, nil
+	case "local":
+		return app.localSources(backendBlock)
+	case "pg":
+		cfg, err := app.buildPGConfig(backendBlock)
+		if err != nil {
+			return nil, err
+		}
+		if app.CLI.AllWorkspaces {
+			return pgWorkspaceSources(ctx, cfg)
+		}
+		return []stateSource{pgSource(cfg, app.currentWorkspace())}, nil
+	case "gcs":
+		url, err := app.buildGCSURL(backendBlock)
+		if err != nil {
+			return nil, err
+		}
+		return []stateSource{urlSource(url)}, nil
+	case "":
+		return nil, fmt.Errorf("backend block has no type label")
+	}
+	return nil, fmt.Errorf("unsupported backend type: %s (supported for auto-detection: s3, local, gcs, azurerm, remote, http, pg)", backendType)
 }
 
-func (app *App) buildS3URL(backendBlock *hclsyntax.Block) (string, error) {
+// buildGCSURL returns the state object of a gcs backend. The backend stores
+// each workspace as <prefix>/<workspace>.tfstate.
+func (app *App) buildGCSURL(backendBlock *hclsyntax.Block) (string, error) {
 	bucket, err := app.getStringAttribute(backendBlock.Body, "bucket")
 	if err != nil {
-		return "", fmt.Errorf("s3 backend: bucket attribute is required: %w", err)
+		return "", fmt.Errorf("gcs backend: bucket attribute is required: %w", err)
 	}
+	var prefix string
+	if _, ok := backendBlock.Body.Attributes["prefix"]; ok {
+		if prefix, err = app.getStringAttribute(backendBlock.Body, "prefix"); err != nil {
+			return "", fmt.Errorf("gcs backend: %w", err)
+		}
+	}
+	object := app.currentWorkspace() + ".tfstate"
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		object = prefix + "/" + object
+	}
+	return fmt.Sprintf("gs://%s/%s", bucket, object), nil
+}
 
-	key, err := app.getStringAttribute(backendBlock.Body, "key")
-	if err != nil {
-		return "", fmt.Errorf("s3 backend: key attribute is required: %w", err)
+// buildAzureRMConfig returns the settings of an azurerm backend in the form
+// tfstate-lookup reads them. The backend stores workspaces other than default
+// as <key>env:<workspace>.
+func (app *App) buildAzureRMConfig(backendBlock *hclsyntax.Block) (map[string]any, error) {
+	config := map[string]any{}
+	for _, name := range []string{"storage_account_name", "container_name", "key"} {
+		value, err := app.getStringAttribute(backendBlock.Body, name)
+		if err != nil {
+			return nil, fmt.Errorf("azurerm backend: %s attribute is required: %w", name, err)
+		}
+		config[name] = value
+	}
+	// The reader expects resource_group_name to be set, even when empty.
+	config["resource_group_name"] = ""
+	for _, name := range []string{"resource_group_name", "subscription_id", "access_key"} {
+		if _, ok := backendBlock.Body.Attributes[name]; !ok {
+			continue
+		}
+		value, err := app.getStringAttribute(backendBlock.Body, name)
+		if err != nil {
+			return nil, fmt.Errorf("azurerm backend: %w", err)
+		}
+		config[name] = value
+	}
+	if _, ok := backendBlock.Body.Attributes["use_azuread_auth"]; ok {
+		value, err := app.getBoolAttribute(backendBlock.Body, "use_azuread_auth")
+		if err != nil {
+			return nil, fmt.Errorf("azurerm backend: %w", err)
+		}
+		config["use_azuread_auth"] = strconv.FormatBool(value)
+	}
+	return config, nil
+}
+
+// buildLocalPath returns the state file of a local backend. Like Terraform, a
+// relative path is taken relative to the configuration directory.
+func (app *App) buildLocalPath(backendBlock *hclsyntax.Block) (string, error) {
+	path := defaultLocalStatePath
+	if _, ok := backendBlock.Body.Attributes["path"]; ok {
+		var err error
+		if path, err = app.getStringAttribute(backendBlock.Body, "path"); err != nil {
+			return "", fmt.Errorf("local backend: %w", err)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	return filepath.Join(app.CLI.Dir, path), nil
+}
+
+func (app *App) getBoolAttribute(body *hclsyntax.Body, name string) (bool, error) {
+	attr, ok := body.Attributes[name]
+	if !ok {
+		return false, fmt.Errorf("attribute %s not found", name)
+	}
+
+	val, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() {
+		return false, fmt.Errorf("error evaluating attribute %s: %v", name, diags)
+	}
+
+	// Values from --backend-config key=value pairs are strings such as "true".
+	val, err := convert.Convert(val, cty.Bool)
+	if err != nil || val.IsNull() {
+		return false, fmt.Errorf("attribute %s is not a bool", name)
 	}
 
-	return fmt.Sprintf("s3://%s/%s", bucket, key), nil
+	return val.True(), nil
 }
 
 func (app *App) getStringAttribute(body *hclsyntax.Body, name string) (string, error) {
@@ -0,0 +1,157 @@
+package tfclean
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// backendConfigSets returns the partial backend configurations to merge into
+// the backend block, one per state to read. Without --backend-config-dir there
+// is a single set made of the --backend-config values; with it, there is one
+// set per *.tfbackend file in the directory, each followed by the
+// --backend-config values. It returns nil when neither option is given.
+func (app *App) backendConfigSets() ([][]*hclsyntax.Body, error) {
+	var common []*hclsyntax.Body
+	for _, item := range app.CLI.BackendConfig {
+		body, err := parseBackendConfigItem(item)
+		if err != nil {
+			return nil, err
+		}
+		common = append(common, body)
+	}
+	if app.CLI.BackendConfigDir == "" {
+		if len(common) == 0 {
+			return nil, nil
+		}
+		return [][]*hclsyntax.Body{common}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(app.CLI.BackendConfigDir, "*.tfbackend"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.tfbackend file in %s", app.CLI.BackendConfigDir)
+	}
+	sort.Strings(files)
+	var sets [][]*hclsyntax.Body
+	for _, file := range files {
+		body, err := parseBackendConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, append([]*hclsyntax.Body{body}, common...))
+	}
+	return sets, nil
+}
+
+// parseBackendConfigItem reads one --backend-config value. As with terraform
+// init, it is a key=value pair when it starts with an identifier followed by
+// "=", and the path of a backend configuration file otherwise. A key=value
+// value is always taken as a string.
+func parseBackendConfigItem(item string) (*hclsyntax.Body, error) {
+	if key, value, ok := strings.Cut(item, "="); ok && hclsyntax.ValidIdentifier(key) {
+		return &hclsyntax.Body{
+			Attributes: hclsyntax.Attributes{
+				key: {Name: key, Expr: &hclsyntax.LiteralValueExpr{Val: cty.StringVal(value)}},
+			},
+		}, nil
+	}
+	return parseBackendConfigFile(item)
+}
+
+func parseBackendConfigFile(path string) (*hclsyntax.Body, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read backend configuration: %w", err)
+	}
+	hclFile, diags := hclparse.NewParser().ParseHCL(data, path)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("error parsing backend configuration: %s", diags)
+	}
+	body, ok := hclFile.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil, fmt.Errorf("%s: unexpected HCL body", path)
+	}
+	return body, nil
+}
+
+// mergeBackendConfig returns a copy of backendBlock with the attributes and
+// nested blocks of each override applied in order; later values win. A nested
+// setting such as assume_role may be given either as a block or, as in
+// .tfbackend files, as an object attribute; both replace the block.
+func mergeBackendConfig(backendBlock *hclsyntax.Block, overrides []*hclsyntax.Body) (*hclsyntax.Block, error) {
+	merged := &hclsyntax.Body{
+		Attributes: hclsyntax.Attributes{},
+		SrcRange:   backendBlock.Body.SrcRange,
+		EndRange:   backendBlock.Body.EndRange,
+	}
+	nested := map[string]*hclsyntax.Block{}
+	var order []string
+	setBlock := func(block *hclsyntax.Block) {
+		if _, ok := nested[block.Type]; !ok {
+			order = append(order, block.Type)
+		}
+		nested[block.Type] = block
+	}
+	for name, attr := range backendBlock.Body.Attributes {
+		merged.Attributes[name] = attr
+	}
+	for _, block := range backendBlock.Body.Blocks {
+		setBlock(block)
+	}
+
+	for _, override := range overrides {
+		for name, attr := range override.Attributes {
+			obj, ok := attr.Expr.(*hclsyntax.ObjectConsExpr)
+			if !ok {
+				merged.Attributes[name] = attr
+				continue
+			}
+			block, err := objectAsBlock(name, obj)
+			if err != nil {
+				return nil, err
+			}
+			delete(merged.Attributes, name)
+			setBlock(block)
+		}
+		for _, block := range override.Blocks {
+			setBlock(block)
+		}
+	}
+	for _, name := range order {
+		merged.Blocks = append(merged.Blocks, nested[name])
+	}
+	return &hclsyntax.Block{
+		Type:   backendBlock.Type,
+		Labels: backendBlock.Labels,
+		Body:   merged,
+	}, nil
+}
+
+// objectAsBlock turns an object attribute such as assume_role = { ... } into
+// the equivalent block.
+func objectAsBlock(name string, obj *hclsyntax.ObjectConsExpr) (*hclsyntax.Block, error) {
+	body := &hclsyntax.Body{Attributes: hclsyntax.Attributes{}, SrcRange: obj.SrcRange}
+	for _, item := range obj.Items {
+		key := hcl.ExprAsKeyword(item.KeyExpr)
+		if key == "" {
+			if v, diags := item.KeyExpr.Value(nil); !diags.HasErrors() && v.Type() == cty.String && !v.IsNull() {
+				key = v.AsString()
+			}
+		}
+		if key == "" {
+			return nil, fmt.Errorf("%s: keys of %s must be names", obj.SrcRange, name)
+		}
+		body.Attributes[key] = &hclsyntax.Attribute{Name: key, Expr: item.ValueExpr, SrcRange: item.ValueExpr.Range()}
+	}
+	return &hclsyntax.Block{Type: name, Body: body}, nil
+}
@@ -0,0 +1,240 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func TestMergeBackendConfig_s3(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "prod.tfbackend")
+	content := `bucket = "prod-states"
+key    = "app/terraform.tfstate"
+region = "eu-west-1"
+assume_role = {
+  role_arn = "arn:aws:iam::111111111111:role/state-reader"
+}
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hclFile, diags := hclparse.NewParser().ParseHCL([]byte("terraform {\n  backend \"s3\" {\n    region = \"us-east-1\"\n    encrypt = true\n  }\n}\n"), "backend.tf")
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+	backendBlock := hclFile.Body.(*hclsyntax.Body).Blocks[0].Body.Blocks[0]
+
+	var overrides []*hclsyntax.Body
+	for _, item := range []string{file, "key=other/terraform.tfstate", "use_path_style=true"} {
+		body, err := parseBackendConfigItem(item)
+		if err != nil {
+			t.Fatalf("parseBackendConfigItem(%q) error = %v", item, err)
+		}
+		overrides = append(overrides, body)
+	}
+	merged, err := mergeBackendConfig(backendBlock, overrides)
+	if err != nil {
+		t.Fatalf("mergeBackendConfig() error = %v", err)
+	}
+	got, err := (&App{}).buildS3Config(merged)
+	if err != nil {
+		t.Fatalf("buildS3Config() error = %v", err)
+	}
+	want := s3Config{
+		bucket:             "prod-states",
+		key:                "other/terraform.tfstate",
+		region:             "eu-west-1",
+		roleARN:            "arn:aws:iam::111111111111:role/state-reader",
+		usePathStyle:       true,
+		workspaceKeyPrefix: "env:",
+	}
+	if got != want {
+		t.Errorf("buildS3Config() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseBackendConfigItem_missingFile(t *testing.T) {
+	if _, err := parseBackendConfigItem(filepath.Join(t.TempDir(), "missing.tfbackend")); err == nil {
+		t.Error("parseBackendConfigItem() error = nil, want an error for a missing file")
+	}
+}
+
+func TestApp_Run_backendConfig(t *testing.T) {
+	const main = "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	const cleaned = "resource \"time_static\" \"bbb\" {}\n\n"
+
+	tests := []struct {
+		name        string
+		noBackend   bool
+		states      map[string]string
+		cli         func(dir string) CLI
+		wantSources []string
+		want        string
+		wantErr     string
+	}{
+		{
+			name:   "key=value",
+			states: map[string]string{"states/dev.tfstate": stateWithResource("bbb")},
+			cli: func(dir string) CLI {
+				return CLI{BackendConfig: []string{"path=" + filepath.Join(dir, "states", "dev.tfstate")}}
+			},
+			wantSources: []string{"states/dev.tfstate"},
+			want:        cleaned,
+		},
+		{
+			name:   "file",
+			states: map[string]string{"states/prod.tfstate": stateWithResource("aaa")},
+			cli: func(dir string) CLI {
+				return CLI{BackendConfig: []string{filepath.Join(dir, "env", "prod.tfbackend")}}
+			},
+			wantSources: []string{"states/prod.tfstate"},
+			want:        main,
+		},
+		{
+			name: "every file in a directory: pending in one",
+			states: map[string]string{
+				"states/dev.tfstate":  stateWithResource("bbb"),
+				"states/prod.tfstate": stateWithResource("aaa"),
+			},
+			cli: func(dir string) CLI {
+				return CLI{BackendConfigDir: filepath.Join(dir, "env")}
+			},
+			wantSources: []string{"states/dev.tfstate", "states/prod.tfstate"},
+			want:        main,
+		},
+		{
+			name: "every file in a directory: applied in all",
+			states: map[string]string{
+				"states/dev.tfstate":  stateWithResource("bbb"),
+				"states/prod.tfstate": stateWithResource("bbb"),
+			},
+			cli: func(dir string) CLI {
+				return CLI{BackendConfigDir: filepath.Join(dir, "env")}
+			},
+			wantSources: []string{"states/dev.tfstate", "states/prod.tfstate"},
+			want:        cleaned,
+		},
+		{
+			name: "directory without .tfbackend files",
+			cli: func(dir string) CLI {
+				return CLI{BackendConfigDir: filepath.Join(dir, "states")}
+			},
+			wantErr: "no *.tfbackend file in",
+		},
+		{
+			name:      "no backend block",
+			noBackend: true,
+			states:    map[string]string{"terraform.tfstate": stateWithResource("bbb")},
+			cli: func(dir string) CLI {
+				return CLI{BackendConfigDir: filepath.Join(dir, "env")}
+			},
+			wantErr: "--backend-config-dir only applies to a backend block, and none is configured",
+		},
+		{
+			name: "combined with --tfstate",
+			cli: func(dir string) CLI {
+				return CLI{BackendConfig: []string{"path=x"}, Tfstate: []string{"x"}}
+			},
+			wantErr: "--backend-config only applies to the auto-detected backend",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, sub := range []string{"env", "states"} {
+				if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			files := map[string]string{
+				"backend.tf":         "terraform {\n  backend \"local\" {}\n}\n",
+				"main.tf":            main,
+				"env/dev.tfbackend":  "path = \"" + filepath.Join(dir, "states", "dev.tfstate") + "\"\n",
+				"env/prod.tfbackend": "path = \"" + filepath.Join(dir, "states", "prod.tfstate") + "\"\n",
+			}
+			for name, content := range tt.states {
+				files[name] = content
+			}
+			if tt.noBackend {
+				delete(files, "backend.tf")
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cli := tt.cli(dir)
+			cli.Dir = dir
+			app := New(&cli)
+			err := app.Run(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			sources, err := app.detectBackendFromConfig(t.Context())
+			if err != nil {
+				t.Fatalf("detectBackendFromConfig() error = %v", err)
+			}
+			var want []string
+			for _, source := range tt.wantSources {
+				want = append(want, filepath.Join(dir, source))
+			}
+			if got := sourceNames(sources); got != strings.Join(want, ", ") {
+				t.Errorf("detectBackendFromConfig() = %v, want %v", got, want)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("main.tf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_backendConfigS3(t *testing.T) {
+	isolateAWSEnv(t)
+	server := newFakeS3(t, map[string]string{"prod-states/app/terraform.tfstate": stateWithResource("bbb")})
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"backend.tf": "terraform {\n  backend \"s3\" {}\n}\n",
+		"main.tf":    "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n",
+		"prod.tfbackend": `bucket = "prod-states"
+key    = "app/terraform.tfstate"
+region = "eu-west-1"
+endpoints = {
+  s3 = "` + server.URL + `"
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cli := CLI{Dir: dir, BackendConfig: []string{filepath.Join(dir, "prod.tfbackend"), "use_path_style=true"}}
+	if err := New(&cli).Run(t.Context()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "resource \"time_static\" \"bbb\" {}\n\n"; string(got) != want {
+		t.Errorf("main.tf = %q, want %q", got, want)
+	}
+}
@@ -1,10 +1,14 @@
 package tfclean
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 )
@@ -124,6 +128,125 @@ terraform {
     bucket = "my-bucket"
   }
 }
+`
+				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
+			},
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name: "GCS backend detected",
+			setupFunc: func(dir string) error {
+				content := `
+terraform {
+  backend "gcs" {
+    bucket = "my-bucket"
+    prefix = "terraform/state"
+  }
+}
+`
+				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
+			},
+			want:    "gs://my-bucket/terraform/state/default.tfstate",
+			wantErr: false,
+		},
+		{
+			name: "GCS backend without prefix",
+			setupFunc: func(dir string) error {
+				content := `
+terraform {
+  backend "gcs" {
+    bucket = "my-bucket"
+  }
+}
+`
+				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
+			},
+			want:    "gs://my-bucket/default.tfstate",
+			wantErr: false,
+		},
+		{
+			name: "GCS backend with trailing slash in prefix",
+			setupFunc: func(dir string) error {
+				content := `
+terraform {
+  backend "gcs" {
+    bucket = "my-bucket"
+    prefix = "terraform/state/"
+  }
+}
+`
+				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
+			},
+			want:    "gs://my-bucket/terraform/state/default.tfstate",
+			wantErr: false,
+		},
+		{
+			name: "AzureRM backend detected",
+			setupFunc: func(dir string) error {
+				content := `
+terraform {
+  backend "azurerm" {
+    resource_group_name  = "tfstate"
+    storage_account_name = "tfstateaccount"
+    container_name       = "tfstate"
+    key                  = "prod.terraform.tfstate"
+  }
+}
+`
+				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
+			},
+			want:    "azurerm://tfstateaccount/tfstate/prod.terraform.tfstate",
+			wantErr: false,
+		},
+		{
+			name: "AzureRM backend in a non-default workspace",
+			setupFunc: func(dir string) error {
+				content := `
+terraform {
+  backend "azurerm" {
+    storage_account_name = "tfstateaccount"
+    container_name       = "tfstate"
+    key                  = "prod.terraform.tfstate"
+  }
+}
+`
+				if err := os.MkdirAll(filepath.Join(dir, ".terraform"), 0755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(filepath.Join(dir, ".terraform", "environment"), []byte("staging"), 0644); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
+			},
+			want:    "azurerm://tfstateaccount/tfstate/prod.terraform.tfstateenv:staging",
+			wantErr: false,
+		},
+		{
+			name: "AzureRM backend missing container_name",
+			setupFunc: func(dir string) error {
+				content := `
+terraform {
+  backend "azurerm" {
+    storage_account_name = "tfstateaccount"
+    key                  = "prod.terraform.tfstate"
+  }
+}
+`
+				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
+			},
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name: "GCS backend missing bucket",
+			setupFunc: func(dir string) error {
+				content := `
+terraform {
+  backend "gcs" {
+    prefix = "terraform/state"
+  }
+}
 `
 				return os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644)
 			},
@@ -134,6 +257,7 @@ terraform {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TF_WORKSPACE", "")
 			tmpDir, err := os.MkdirTemp("", "tfclean-test-*")
 			if err != nil {
 				t.Fatalf("Failed to create temp dir: %v", err)
@@ -150,7 +274,8 @@ terraform {
 				},
 			}
 
-			got, err := app.detectBackendFromConfig()
+			sources, err := app.detectBackendFromConfig(t.Context())
+			got := sourceNames(sources)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("detectBackendFromConfig() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -162,7 +287,16 @@ terraform {
 	}
 }
 
-func TestApp_buildS3URL(t *testing.T) {
+// sourceNames joins the names of the detected state sources.
+func sourceNames(sources []stateSource) string {
+	var names []string
+	for _, source := range sources {
+		names = append(names, source.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestApp_buildS3Config(t *testing.T) {
 	tests := []struct {
 		name    string
 		content string
@@ -179,7 +313,7 @@ terraform {
   }
 }
 `,
-			want:    "s3://my-bucket/terraform.tfstate",
+			want:    "my-bucket/terraform.tfstate",
 			wantErr: false,
 		},
 		{
@@ -192,7 +326,7 @@ terraform {
   }
 }
 `,
-			want:    "s3://my-bucket/env/prod/terraform.tfstate",
+			want:    "my-bucket/env/prod/terraform.tfstate",
 			wantErr: false,
 		},
 		{
@@ -262,74 +396,195 @@ terraform {
 			}
 
 			app := &App{}
-			got, err := app.buildS3URL(backendBlock)
+			cfg, err := app.buildS3Config(backendBlock)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("buildS3URL() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("buildS3Config() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
-				t.Errorf("buildS3URL() = %v, want %v", got, tt.want)
+			if got := cfg.bucket + "/" + cfg.key; !tt.wantErr && got != tt.want {
+				t.Errorf("buildS3Config() bucket/key = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestApp_buildStateURLFromBackend(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "tfclean-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	content := `
+func TestApp_backendSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "s3",
+			content: `
 terraform {
   backend "s3" {
     bucket = "my-bucket"
     key    = "terraform.tfstate"
   }
 }
-`
-
-	filePath := filepath.Join(tmpDir, "backend.tf")
-	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
-		t.Fatalf("Failed to write test file: %v", err)
-	}
-
-	parser := hclparse.NewParser()
-	hclFile, diags := parser.ParseHCLFile(filePath)
-	if diags.HasErrors() {
-		t.Fatalf("Failed to parse HCL: %v", diags)
+`,
+			want: "s3://my-bucket/terraform.tfstate",
+		},
+		{
+			name: "gcs",
+			content: `
+terraform {
+  backend "gcs" {
+    bucket = "my-bucket"
+    prefix = "app"
+  }
+}
+`,
+			want: "gs://my-bucket/app/default.tfstate",
+		},
+		{
+			name: "unsupported",
+			content: `
+terraform {
+  backend "consul" {
+    path = "app"
+  }
+}
+`,
+			wantErr: "unsupported backend type: consul",
+		},
 	}
 
-	body, ok := hclFile.Body.(*hclsyntax.Body)
-	if !ok {
-		t.Fatalf("Failed to cast body")
-	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, diags := hclsyntax.ParseConfig([]byte(tt.content), "backend.tf", hcl.InitialPos)
+			if diags.HasErrors() {
+				t.Fatalf("Failed to parse HCL: %v", diags)
+			}
+			backendBlock := file.Body.(*hclsyntax.Body).Blocks[0].Body.Blocks[0]
 
-	var backendBlock *hclsyntax.Block
-	for _, block := range body.Blocks {
-		if block.Type == "terraform" {
-			for _, b := range block.Body.Blocks {
-				if b.Type == "backend" {
-					backendBlock = b
-					break
+			app := &App{CLI: &CLI{Dir: t.TempDir()}}
+			sources, err := app.backendSources(context.Background(), backendBlock)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("backendSources() error = %v, want %q", err, tt.wantErr)
 				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("backendSources() error = %v", err)
 			}
-		}
+			if got := sourceNames(sources); got != tt.want {
+				t.Errorf("backendSources() = %v, want %v", got, tt.want)
+			}
+		})
 	}
+}
 
-	if backendBlock == nil {
-		t.Fatalf("Backend block not found")
+func TestApp_detectBackendFromConfig_gcsWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	content := `
+terraform {
+  backend "gcs" {
+    bucket = "my-bucket"
+    prefix = "terraform/state"
+  }
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
 	}
+	t.Setenv("TF_WORKSPACE", "staging")
 
-	app := &App{}
-	got, err := app.buildStateURLFromBackend(backendBlock)
+	app := &App{CLI: &CLI{Dir: dir}}
+	sources, err := app.detectBackendFromConfig(t.Context())
+	got := sourceNames(sources)
 	if err != nil {
-		t.Errorf("buildStateURLFromBackend() error = %v", err)
-		return
+		t.Fatalf("detectBackendFromConfig() error = %v", err)
+	}
+	if want := "gs://my-bucket/terraform/state/staging.tfstate"; got != want {
+		t.Errorf("detectBackendFromConfig() = %v, want %v", got, want)
 	}
-	want := "s3://my-bucket/terraform.tfstate"
-	if got != want {
-		t.Errorf("buildStateURLFromBackend() = %v, want %v", got, want)
+}
+
+func TestApp_buildAzureRMConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]any
+		wantErr bool
+	}{
+		{
+			name: "Required attributes only",
+			content: `
+terraform {
+  backend "azurerm" {
+    storage_account_name = "tfstateaccount"
+    container_name       = "tfstate"
+    key                  = "prod.terraform.tfstate"
+  }
+}
+`,
+			want: map[string]any{
+				"storage_account_name": "tfstateaccount",
+				"container_name":       "tfstate",
+				"key":                  "prod.terraform.tfstate",
+				"resource_group_name":  "",
+			},
+		},
+		{
+			name: "Optional attributes passed through",
+			content: `
+terraform {
+  backend "azurerm" {
+    resource_group_name  = "tfstate-rg"
+    storage_account_name = "tfstateaccount"
+    container_name       = "tfstate"
+    key                  = "prod.terraform.tfstate"
+    subscription_id      = "00000000-0000-0000-0000-000000000000"
+    use_azuread_auth     = true
+  }
+}
+`,
+			want: map[string]any{
+				"storage_account_name": "tfstateaccount",
+				"container_name":       "tfstate",
+				"key":                  "prod.terraform.tfstate",
+				"resource_group_name":  "tfstate-rg",
+				"subscription_id":      "00000000-0000-0000-0000-000000000000",
+				"use_azuread_auth":     "true",
+			},
+		},
+		{
+			name: "use_azuread_auth is not a bool",
+			content: `
+terraform {
+  backend "azurerm" {
+    storage_account_name = "tfstateaccount"
+    container_name       = "tfstate"
+    key                  = "prod.terraform.tfstate"
+    use_azuread_auth     = "yes"
+  }
+}
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hclFile, diags := hclparse.NewParser().ParseHCL([]byte(tt.content), "backend.tf")
+			if diags.HasErrors() {
+				t.Fatalf("Failed to parse HCL: %v", diags)
+			}
+			body := hclFile.Body.(*hclsyntax.Body)
+			backendBlock := body.Blocks[0].Body.Blocks[0]
+
+			app := &App{}
+			got, err := app.buildAzureRMConfig(backendBlock)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildAzureRMConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildAzureRMConfig() = %v, want %v", got, tt.want)
+			}
+		})
 	}
 }
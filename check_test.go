@@ -0,0 +1,63 @@
+package tfclean
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApp_Run_check(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+		wantOut bool
+	}{
+		{
+			name:    "removable block exits with ErrCleanupNeeded",
+			content: "resource \"null_resource\" \"keep\" {}\nmoved {\n  from = module.foo[\"a\"]\n  to   = module.foo[\"b\"]\n}\n",
+			wantErr: ErrCleanupNeeded,
+			wantOut: true,
+		},
+		{
+			name:    "nothing to remove succeeds",
+			content: "resource \"null_resource\" \"keep\" {}\n",
+			wantErr: nil,
+			wantOut: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "main.tf")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("write fixture: %v", err)
+			}
+
+			var out bytes.Buffer
+			app := New(&CLI{Dir: dir, Check: true, All: true})
+			app.stdout = &out
+			err := app.Run(t.Context())
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("file was modified\n got: %q\nwant: %q", string(got), tt.content)
+			}
+			if wantOut := path + "\n"; tt.wantOut && out.String() != wantOut {
+				t.Errorf("output = %q, want %q", out.String(), wantOut)
+			}
+			if !tt.wantOut && out.Len() != 0 {
+				t.Errorf("output = %q, want empty", out.String())
+			}
+		})
+	}
+}
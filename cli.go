@@ -2,6 +2,7 @@ package tfclean
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/alecthomas/kong"
 )
@@ -9,13 +10,70 @@ import (
 var Version = "dev"
 var Revision = "HEAD"
 
+// ExitCodeCleanupNeeded is the exit status for --check when applied blocks
+// remain. Real errors such as state read or HCL parse failures exit with 1.
+const ExitCodeCleanupNeeded = 2
+
+// ErrCleanupNeeded is returned by RunCLI in --check mode when at least one file
+// still contains blocks that could be removed.
+var ErrCleanupNeeded = errors.New("applied blocks can be removed")
+
 type GlobalOptions struct {
 }
 
 type CLI struct {
-	Tfstate []string    `help:"Terraform state file (repeatable; S3 backend is auto-detected from .tf files when omitted). When multiple states are given, a block is removed only if it has been applied in all of them."`
-	Dir     string      `arg:"" required:"" help:"Directory to clean"`
-	Version VersionFlag `name:"version" help:"show version"`
+	Tfstate          []string    `help:"Terraform state file (repeatable; the backend is auto-detected from .tf files when omitted). A local directory or a glob pattern such as s3://bucket/dev/*/terraform.tfstate stands for every state it matches, and - reads the state from stdin. When multiple states are given, a block is removed only if it has been applied in all of them. Failing to read any state is an error."`
+	StateCommand     []string    `name:"state-command" sep:"none" help:"Command whose output is a Terraform state, run with sh -c, such as \"terraform -chdir=x state pull\" (repeatable). Each command counts as one state, like --tfstate; a non-zero exit status is an error."`
+	Plan             string      `type:"path" help:"JSON of a saved plan (terraform show -json tfplan) to decide from instead of state. A block counts as applied when the plan no longer moves, imports or forgets anything for it."`
+	All              bool        `help:"Remove every moved/import/removed block without consulting any state. Without this flag, a state that cannot be detected or read is an error."`
+	BackendConfig    []string    `name:"backend-config" help:"Partial backend configuration merged into the detected backend block, as a .tfbackend file or key=value (repeatable, like terraform init -backend-config)"`
+	BackendConfigDir string      `name:"backend-config-dir" type:"path" help:"Read one state per *.tfbackend file in this directory, each merged into the detected backend block. A block is removed only if it has been applied in all of them."`
+	AllWorkspaces    bool        `name:"all-workspaces" help:"Check the auto-detected backend's states for every workspace instead of only the current one (s3 and pg backends; the local backend always checks every workspace). A block is removed only if it has been applied in all of them."`
+	Strict           bool        `help:"Fail the run when the from/to address of a block cannot be resolved, instead of keeping the block"`
+	DryRun           bool        `name:"dry-run" help:"Print a unified diff of the changes instead of rewriting or deleting files"`
+	Check            bool        `help:"Do not modify files; list the files that could be cleaned and exit with status 2 if there are any"`
+	Report           string      `enum:",json" default:"" help:"Print a report of every moved/import/removed block and the decision made for it (json)"`
+	ReportFile       string      `name:"report-file" type:"path" help:"Write the --report output to this file instead of stdout"`
+	Version          VersionFlag `name:"version" help:"show version"`
+
+	Clean   CleanCmd   `cmd:"" default:"withargs" help:"Remove applied moved/import/removed blocks (default)"`
+	Explain ExplainCmd `cmd:"" help:"Show the per-state evidence behind the decision for a block"`
+
+	// Dir is the directory the selected command works on. RunCLI copies it from
+	// the command's arguments so App does not depend on which command ran.
+	Dir string `kong:"-"`
+}
+
+// detectionOptions returns the options given that only apply when the backend
+// is auto-detected.
+func (cli *CLI) detectionOptions() []string {
+	opts := cli.backendConfigOptions()
+	if cli.AllWorkspaces {
+		opts = append(opts, "--all-workspaces")
+	}
+	return opts
+}
+
+// backendConfigOptions returns the options that override settings of the
+// backend block.
+func (cli *CLI) backendConfigOptions() []string {
+	var opts []string
+	if len(cli.BackendConfig) > 0 {
+		opts = append(opts, "--backend-config")
+	}
+	if cli.BackendConfigDir != "" {
+		opts = append(opts, "--backend-config-dir")
+	}
+	return opts
+}
+
+type CleanCmd struct {
+	Dir string `arg:"" required:"" help:"Directory to clean"`
+}
+
+type ExplainCmd struct {
+	Target string `arg:"" required:"" help:"Block to explain, as <file>:<line> or as a from/to address such as module.foo"`
+	Dir    string `arg:"" optional:"" help:"Directory containing the configuration (defaults to the directory of <file>, or the current directory for an address)"`
 }
 
 type VersionFlag string
@@ -36,11 +94,16 @@ func RunCLI(ctx context.Context, args []string) error {
 	if err != nil {
 		return fmt.Errorf("error creating CLI parser: %w", err)
 	}
-	_, err = parser.Parse(args)
+	kctx, err := parser.Parse(args)
 	if err != nil {
 		fmt.Printf("error parsing CLI: %v\n", err)
 		return fmt.Errorf("error parsing CLI: %w", err)
 	}
 	app := New(&cli)
+	if kctx.Selected() != nil && kctx.Selected().Name == "explain" {
+		cli.Dir = cli.Explain.Dir
+		return app.Explain(ctx, cli.Explain.Target)
+	}
+	cli.Dir = cli.Clean.Dir
 	return app.Run(ctx)
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/takaishi/tfclean"
 	"log"
 	"os"
@@ -20,6 +21,10 @@ func main() {
 	ctx, stop := signal.NotifyContext(ctx, []os.Signal{os.Interrupt}...)
 	defer stop()
 	if err := tfclean.RunCLI(ctx, os.Args[1:]); err != nil {
+		if errors.Is(err, tfclean.ErrCleanupNeeded) {
+			log.Printf("%v", err)
+			os.Exit(tfclean.ExitCodeCleanupNeeded)
+		}
 		log.Printf("error: %v", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,242 @@
+package tfclean
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContextLines = 3
+
+type diffOpKind int
+
+const (
+	diffEqual diffOpKind = iota
+	diffDelete
+	diffInsert
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line string
+}
+
+// unifiedDiff returns a unified diff between a and b in the format produced by
+// `diff -u`. It returns an empty string when a and b are identical. When
+// deleted is true the new side is labelled /dev/null, which is how a file
+// removal is shown.
+func unifiedDiff(path string, a, b []byte, deleted bool) string {
+	if string(a) == string(b) && !deleted {
+		return ""
+	}
+	ops := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	var sb strings.Builder
+	newName := path
+	if deleted {
+		newName = "/dev/null"
+	}
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", path, newName)
+
+	// Walk the edit script and emit one hunk per group of changes that are
+	// closer together than twice the context size.
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+		start := max(i-diffContextLines, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != diffEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == diffEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				end = min(end+diffContextLines, run)
+				break
+			}
+			end = run
+		}
+		writeHunk(&sb, ops, start, end)
+		i = end
+	}
+	return sb.String()
+}
+
+func writeHunk(sb *strings.Builder, ops []diffOp, start, end int) {
+	// Line numbers of the hunk start are derived from the ops that precede it.
+	aLine, bLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != diffInsert {
+			aLine++
+		}
+		if op.kind != diffDelete {
+			bLine++
+		}
+	}
+	aCount, bCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != diffInsert {
+			aCount++
+		}
+		if op.kind != diffDelete {
+			bCount++
+		}
+	}
+	// An empty side is reported as starting at the line before the hunk.
+	if aCount == 0 {
+		aLine--
+	}
+	if bCount == 0 {
+		bLine--
+	}
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+	for _, op := range ops[start:end] {
+		prefix := " "
+		switch op.kind {
+		case diffDelete:
+			prefix = "-"
+		case diffInsert:
+			prefix = "+"
+		}
+		sb.WriteString(prefix)
+		sb.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// splitLines splits s into lines, keeping the trailing newline of each line so
+// a missing newline at the end of the file is preserved.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b. The common prefix
+// and suffix are matched up front, which leaves only the changed region for
+// the Myers algorithm.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{diffEqual, line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{diffEqual, line})
+	}
+	return ops
+}
+
+// myersDiff computes a shortest edit script from a to b with the Myers
+// algorithm. When one side is empty, as when a whole file is deleted, the
+// script is trivial and no search is needed.
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		ops := make([]diffOp, 0, n+m)
+		for _, line := range a {
+			ops = append(ops, diffOp{diffDelete, line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{diffInsert, line})
+		}
+		return ops
+	}
+
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	// trace[d] keeps the diagonals -d..d of v as they were before step d,
+	// which is all backtracking reads.
+	var trace [][]int
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace, d)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrackDiff walks trace back from step d to build the edit script. trace[d]
+// holds diagonal k at index k+d.
+func backtrackDiff(a, b []string, trace [][]int, d int) []diffOp {
+	x, y := len(a), len(b)
+	var ops []diffOp
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{diffEqual, a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{diffInsert, b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{diffDelete, a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{diffEqual, a[x]})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
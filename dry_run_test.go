@@ -0,0 +1,138 @@
+package tfclean
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestApp_processFile_dryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantDiff string
+	}{
+		{
+			name:    "applied block is shown as removed",
+			content: "resource \"null_resource\" \"keep\" {}\nmoved {\n  from = module.foo[\"a\"]\n  to   = module.foo[\"b\"]\n}\n",
+			wantDiff: `--- %[1]s
++++ %[1]s
+@@ -1,5 +1 @@
+ resource "null_resource" "keep" {}
+-moved {
+-  from = module.foo["a"]
+-  to   = module.foo["b"]
+-}
+`,
+		},
+		{
+			name:    "file that would become empty is shown as deleted",
+			content: "moved {\n  from = module.foo[\"a\"]\n  to   = module.foo[\"b\"]\n}\n",
+			wantDiff: `--- %[1]s
++++ /dev/null
+@@ -1,4 +0,0 @@
+-moved {
+-  from = module.foo["a"]
+-  to   = module.foo["b"]
+-}
+`,
+		},
+		{
+			name:     "unchanged file prints nothing",
+			content:  "resource \"null_resource\" \"keep\" {}\n",
+			wantDiff: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.tf")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("write fixture: %v", err)
+			}
+
+			var out bytes.Buffer
+			app := New(&CLI{Dir: dir, DryRun: true})
+			app.stdout = &out
+			if err := app.processFile(path, nil); err != nil {
+				t.Fatalf("processFile: %v", err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("expected file to be left in place, got err = %v", err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("file was modified\n got: %q\nwant: %q", string(got), tt.content)
+			}
+			want := tt.wantDiff
+			if want != "" {
+				want = fmt.Sprintf(want, path)
+			}
+			if out.String() != want {
+				t.Errorf("diff mismatch\n got: %q\nwant: %q", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	b := "1\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n15\n"
+	want := `--- main.tf
++++ main.tf
+@@ -1,5 +1,4 @@
+ 1
+-2
+ 3
+ 4
+ 5
+@@ -11,5 +10,4 @@
+ 11
+ 12
+ 13
+-14
+ 15
+`
+	if got := unifiedDiff("main.tf", []byte(a), []byte(b), false); got != want {
+		t.Errorf("unifiedDiff() mismatch\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestUnifiedDiff_large(t *testing.T) {
+	var lines, changed []string
+	for i := 1; i <= 7500; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+		if i%100 == 0 {
+			changed = append(changed, fmt.Sprintf("changed %d", i))
+		} else {
+			changed = append(changed, lines[i-1])
+		}
+	}
+	a := []byte(strings.Join(lines, "\n") + "\n")
+	b := []byte(strings.Join(changed, "\n") + "\n")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	got := unifiedDiff("main.tf", a, nil, false)
+	runtime.ReadMemStats(&after)
+	if !strings.HasPrefix(got, "--- main.tf\n+++ main.tf\n@@ -1,7500 +0,0 @@\n-line 1\n") || strings.Count(got, "\n-") != 7500 {
+		t.Errorf("unifiedDiff() of a deleted file = %.80q...", got)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("unifiedDiff() of a deleted file allocated %d bytes", alloc)
+	}
+
+	got = unifiedDiff("main.tf", a, b, false)
+	if n := strings.Count(got, "\n@@ "); n != 75 {
+		t.Errorf("unifiedDiff() has %d hunks, want 75", n)
+	}
+	if !strings.Contains(got, "@@ -97,7 +97,7 @@\n line 97\n line 98\n line 99\n-line 100\n+changed 100\n line 101\n") {
+		t.Errorf("unifiedDiff() is missing the hunk of line 100")
+	}
+}
@@ -0,0 +1,277 @@
+package tfclean
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// explainedBlock is a moved, import or removed block selected by the explain
+// command.
+type explainedBlock struct {
+	block *hclsyntax.Block
+	from  address
+	// to holds one address per for_each element of an import block.
+	to      []address
+	ignored bool
+	// err is set when from or to cannot be resolved.
+	err error
+}
+
+// Explain prints, for every block matching target, the states that were
+// consulted, what each of them says about the block's addresses and the rule
+// that made the block count as applied or pending. target is either
+// <file>:<line> or an address used as the from or to of a block.
+func (app *App) Explain(ctx context.Context, target string) error {
+	file, line, isFileLine := parseFileLine(target)
+	if app.CLI.Dir == "" {
+		app.CLI.Dir = "."
+		if isFileLine {
+			app.CLI.Dir = filepath.Dir(file)
+		}
+	}
+
+	var blocks []explainedBlock
+	var err error
+	if isFileLine {
+		blocks, err = app.findBlocks([]string{file}, func(b explainedBlock) bool {
+			return b.block.Range().Start.Line <= line && line <= b.block.Range().End.Line
+		})
+	} else {
+		var files []string
+		files, err = app.configFiles()
+		if err != nil {
+			return err
+		}
+		// Compare parsed addresses so spelling differences such as spacing
+		// inside an index do not matter.
+		want := target
+		if addr, err := parseAddressString(target); err == nil {
+			want = addr.String()
+		}
+		blocks, err = app.findBlocks(files, func(b explainedBlock) bool {
+			if b.err != nil {
+				return false
+			}
+			if b.from.String() == want {
+				return true
+			}
+			for _, to := range b.to {
+				if to.String() == want {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return fmt.Errorf("no moved, import or removed block matches %s", target)
+	}
+
+	states, sources, err := app.loadStates(ctx)
+	if err != nil {
+		return err
+	}
+	for i, b := range blocks {
+		if i > 0 {
+			fmt.Fprintln(app.stdout)
+		}
+		if err := app.explainBlock(app.stdout, b, states, sources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseFileLine splits target into a file and a line number when it has the
+// form <file>:<line> and the file exists.
+func parseFileLine(target string) (string, int, bool) {
+	i := strings.LastIndex(target, ":")
+	if i < 0 {
+		return "", 0, false
+	}
+	line, err := strconv.Atoi(target[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	if _, err := os.Stat(target[:i]); err != nil {
+		return "", 0, false
+	}
+	return target[:i], line, true
+}
+
+func (app *App) configFiles() ([]string, error) {
+	entries, err := os.ReadDir(app.CLI.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".tf" {
+			continue
+		}
+		files = append(files, filepath.Join(app.CLI.Dir, entry.Name()))
+	}
+	return files, nil
+}
+
+func (app *App) findBlocks(files []string, match func(explainedBlock) bool) ([]explainedBlock, error) {
+	var blocks []explainedBlock
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fileIgnored, ignoredLines, err := app.collectIgnoreAnnotations(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		hclFile, diags := hclparse.NewParser().ParseHCL(data, file)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("error parsing HCL: %s", diags)
+		}
+		body, ok := hclFile.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		for _, block := range body.Blocks {
+			if !isCleanableBlock(block) {
+				continue
+			}
+			b := explainedBlock{
+				block:   block,
+				ignored: fileIgnored || ignoredLines[block.Range().Start.Line-1],
+			}
+			b.from, b.to, b.err = app.blockAddresses(block, body)
+			if match(b) {
+				blocks = append(blocks, b)
+			}
+		}
+	}
+	return blocks, nil
+}
+
+func (app *App) evidenceFor(state *tfstate.TFState, b explainedBlock) (blockEvidence, error) {
+	switch b.block.Type {
+	case "import":
+		return app.importEvidence(state, b.to)
+	case "moved":
+		return app.movedEvidence(state, b.from, b.to[0])
+	default:
+		return app.removedEvidence(state, b.from)
+	}
+}
+
+func (app *App) explainBlock(w io.Writer, b explainedBlock, states []*tfstate.TFState, sources []string) error {
+	rng := b.block.Range()
+	fmt.Fprintf(w, "%s:%d-%d: %s", rng.Filename, rng.Start.Line, rng.End.Line, b.block.Type)
+	if from := b.from.String(); from != "" {
+		fmt.Fprintf(w, " from=%s", from)
+	}
+	if to := addressList(b.to); to != "" {
+		fmt.Fprintf(w, " to=%s", to)
+	}
+	fmt.Fprintln(w)
+
+	if b.ignored {
+		fmt.Fprintln(w, "  decision: ignored (tfclean-ignore annotation)")
+		return nil
+	}
+	if b.err != nil {
+		fmt.Fprintf(w, "  decision: error (%v), the block is kept\n", b.err)
+		return nil
+	}
+	if app.plan != nil {
+		evidence := app.planEvidence(app.plan, b.block.Type, b.from, b.to)
+		status := "applied"
+		if !evidence.applied {
+			status = "pending"
+		}
+		fmt.Fprintf(w, "  plan %s: %s\n", sources[0], status)
+		for _, lookup := range evidence.lookups {
+			writeLookup(w, lookup)
+		}
+		fmt.Fprintf(w, "    rule: %s\n", evidence.rule)
+		if evidence.applied {
+			fmt.Fprintln(w, "  decision: removed (no planned action for the block)")
+		} else {
+			fmt.Fprintln(w, "  decision: kept (the plan still acts on the block)")
+		}
+		return nil
+	}
+	if len(states) == 0 {
+		fmt.Fprintln(w, "  decision: removed (--all given, no state consulted)")
+		return nil
+	}
+
+	pending := 0
+	for i, state := range states {
+		evidence, err := app.evidenceFor(state, b)
+		if err != nil {
+			return fmt.Errorf("could not check %s against %s: %w", b.block.Type, sources[i], err)
+		}
+		status := "applied"
+		if !evidence.applied {
+			status = "pending"
+			pending++
+		}
+		fmt.Fprintf(w, "  state %s: %s\n", sources[i], status)
+		for _, lookup := range evidence.lookups {
+			writeLookup(w, lookup)
+		}
+		fmt.Fprintf(w, "    rule: %s\n", evidence.rule)
+	}
+	if pending > 0 {
+		fmt.Fprintf(w, "  decision: kept (pending in %d of %d states)\n", pending, len(states))
+	} else {
+		fmt.Fprintf(w, "  decision: removed (applied in all %d states)\n", len(states))
+	}
+	return nil
+}
+
+func writeLookup(w io.Writer, lookup addressLookup) {
+	if lookup.planned {
+		if !lookup.found {
+			fmt.Fprintf(w, "    %-4s %s: no planned change\n", lookup.role, lookup.address)
+			return
+		}
+		fmt.Fprintf(w, "    %-4s %s: %d planned change(s)\n", lookup.role, lookup.address, len(lookup.matches))
+		for _, match := range lookup.matches {
+			fmt.Fprintf(w, "           %s\n", match)
+		}
+		return
+	}
+	if !lookup.module {
+		switch {
+		case !lookup.found:
+			fmt.Fprintf(w, "    %-4s %s: not found\n", lookup.role, lookup.address)
+		case len(lookup.matches) == 1 && lookup.matches[0] == lookup.address:
+			fmt.Fprintf(w, "    %-4s %s: found\n", lookup.role, lookup.address)
+		default:
+			fmt.Fprintf(w, "    %-4s %s: %d instance(s) in state\n", lookup.role, lookup.address, len(lookup.matches))
+			for _, match := range lookup.matches {
+				fmt.Fprintf(w, "           %s\n", match)
+			}
+		}
+		return
+	}
+	if !lookup.found {
+		fmt.Fprintf(w, "    %-4s %s: no resources under this module\n", lookup.role, lookup.address)
+		return
+	}
+	fmt.Fprintf(w, "    %-4s %s: %d resource(s) under this module\n", lookup.role, lookup.address, len(lookup.matches))
+	for _, match := range lookup.matches {
+		fmt.Fprintf(w, "           %s\n", match)
+	}
+}
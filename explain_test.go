@@ -0,0 +1,136 @@
+package tfclean
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApp_Explain(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.tf")
+	content := `resource "time_static" "bbb" {}
+
+moved {
+  from = time_static.aaa
+  to   = time_static.bbb
+}
+
+# tfclean-ignore
+removed {
+  from = time_static.ccc
+}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	applied := filepath.Join(dir, "applied.tfstate")
+	pending := filepath.Join(dir, "pending.tfstate")
+	if err := os.WriteFile(applied, []byte(stateWithResource("bbb")), 0644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	if err := os.WriteFile(pending, []byte(stateWithResource("aaa")), 0644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+
+	movedOutput := path + `:3-6: moved from=time_static.aaa to=time_static.bbb
+  state ` + applied + `: applied
+    from time_static.aaa: not found
+    to   time_static.bbb: found
+    rule: from is gone and to is in state, so the move has been applied
+  state ` + pending + `: pending
+    from time_static.aaa: found
+    to   time_static.bbb: not found
+    rule: from is still in state, so the move is pending
+  decision: kept (pending in 1 of 2 states)
+`
+
+	tests := []struct {
+		name    string
+		target  string
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "file and line inside the block",
+			target: path + ":4",
+			want:   movedOutput,
+		},
+		{
+			name:   "address used as to",
+			target: "time_static.bbb",
+			dir:    dir,
+			want:   movedOutput,
+		},
+		{
+			name:   "ignored block",
+			target: "time_static.ccc",
+			dir:    dir,
+			want: path + `:9-11: removed from=time_static.ccc
+  decision: ignored (tfclean-ignore annotation)
+`,
+		},
+		{
+			name:    "no matching block",
+			target:  "time_static.zzz",
+			dir:     dir,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := New(&CLI{Dir: tt.dir, Tfstate: []string{applied, pending}})
+			app.stdout = &out
+			err := app.Explain(t.Context(), tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Explain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("Explain() output mismatch\n got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCLI_explainSubcommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte("moved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"), 0644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	// explain never modifies files, even with --all.
+	if err := RunCLI(t.Context(), []string{"explain", "--all", "time_static.aaa", dir}); err != nil {
+		t.Fatalf("RunCLI() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !strings.Contains(string(got), "moved {") {
+		t.Errorf("explain modified the file: %q", got)
+	}
+}
+
+func TestRunCLI_cleanIsDefaultCommand(t *testing.T) {
+	for _, args := range [][]string{{"--all"}, {"clean", "--all"}} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "main.tf")
+		if err := os.WriteFile(path, []byte("resource \"time_static\" \"bbb\" {}\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"), 0644); err != nil {
+			t.Fatalf("write fixture: %v", err)
+		}
+		if err := RunCLI(t.Context(), append(args, dir)); err != nil {
+			t.Fatalf("RunCLI(%v) error = %v", args, err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if want := "resource \"time_static\" \"bbb\" {}\n"; string(got) != want {
+			t.Errorf("RunCLI(%v) content = %q, want %q", args, got, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package tfclean
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// forEachFunctions are the Terraform functions available when evaluating
+// for_each and the locals it refers to. Only functions that can be evaluated
+// without the configuration's providers or filesystem are included.
+var forEachFunctions = map[string]function.Function{
+	"concat":   stdlib.ConcatFunc,
+	"distinct": stdlib.DistinctFunc,
+	"flatten":  stdlib.FlattenFunc,
+	"format":   stdlib.FormatFunc,
+	"join":     stdlib.JoinFunc,
+	"keys":     stdlib.KeysFunc,
+	"length":   stdlib.LengthFunc,
+	"lower":    stdlib.LowerFunc,
+	"merge":    stdlib.MergeFunc,
+	"setunion": stdlib.SetUnionFunc,
+	"split":    stdlib.SplitFunc,
+	"tolist":   stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
+	"tomap":    stdlib.MakeToFunc(cty.Map(cty.DynamicPseudoType)),
+	"toset":    stdlib.MakeToFunc(cty.Set(cty.DynamicPseudoType)),
+	"upper":    stdlib.UpperFunc,
+	"values":   stdlib.ValuesFunc,
+	"zipmap":   stdlib.ZipmapFunc,
+}
+
+// importTargets returns the addresses an import block imports into. Without
+// for_each that is the single to address; with for_each, to is evaluated once
+// per element with each.key and each.value set. body is the file containing
+// the block, whose locals are available alongside those of the directory.
+func (app *App) importTargets(block *hclsyntax.Block, body *hclsyntax.Body) ([]address, error) {
+	forEach, ok := block.Body.Attributes["for_each"]
+	if !ok {
+		to, err := app.blockAddress(block, "to", nil)
+		if err != nil {
+			return nil, err
+		}
+		return []address{to}, nil
+	}
+	if _, ok := block.Body.Attributes["to"]; !ok {
+		_, err := app.blockAddress(block, "to", nil)
+		return nil, err
+	}
+	ctx, err := app.evalContext(body)
+	if err != nil {
+		return nil, err
+	}
+	eachContexts, err := expandForEach(forEach.Expr, ctx)
+	if err != nil {
+		rng := forEach.Range()
+		return nil, fmt.Errorf("%s:%d: cannot evaluate for_each of %s block: %w", rng.Filename, rng.Start.Line, block.Type, err)
+	}
+	targets := make([]address, 0, len(eachContexts))
+	for _, eachCtx := range eachContexts {
+		to, err := app.blockAddress(block, "to", eachCtx)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, to)
+	}
+	return targets, nil
+}
+
+// expandForEach evaluates a for_each expression and returns a child of ctx for
+// each element, with each.key and each.value set as Terraform does. As in
+// Terraform, the value must be a map, an object or a set of strings.
+func expandForEach(expr hcl.Expression, ctx *hcl.EvalContext) ([]*hcl.EvalContext, error) {
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("%s", diags)
+	}
+	if !value.IsWhollyKnown() {
+		return nil, fmt.Errorf("the value depends on something that is only known to Terraform, such as a variable or a resource attribute")
+	}
+	if value.IsNull() {
+		return nil, fmt.Errorf("the value is null")
+	}
+	ty := value.Type()
+	switch {
+	case ty.IsMapType(), ty.IsObjectType():
+	case ty.IsSetType() && ty.ElementType() == cty.String:
+	default:
+		return nil, fmt.Errorf("the value must be a map or a set of strings, got %s", ty.FriendlyName())
+	}
+
+	var contexts []*hcl.EvalContext
+	for it := value.ElementIterator(); it.Next(); {
+		key, val := it.Element()
+		if ty.IsSetType() {
+			key = val
+		}
+		if key.IsNull() {
+			return nil, fmt.Errorf("the value must not contain null keys")
+		}
+		child := ctx.NewChild()
+		child.Variables = map[string]cty.Value{
+			"each": cty.ObjectVal(map[string]cty.Value{"key": key, "value": val}),
+		}
+		contexts = append(contexts, child)
+	}
+	return contexts, nil
+}
+
+// evalContext returns the context for evaluating for_each: the locals of the
+// configuration directory and of body, and a set of pure functions. Locals that
+// cannot be evaluated statically, e.g. because they refer to a variable, are
+// left unknown.
+func (app *App) evalContext(body *hclsyntax.Body) (*hcl.EvalContext, error) {
+	exprs, err := app.directoryLocals()
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]hcl.Expression, len(exprs))
+	for name, expr := range exprs {
+		merged[name] = expr
+	}
+	for name, expr := range localExpressions(body) {
+		merged[name] = expr
+	}
+	return &hcl.EvalContext{
+		Variables: map[string]cty.Value{"local": cty.ObjectVal(evaluateLocals(merged))},
+		Functions: forEachFunctions,
+	}, nil
+}
+
+// directoryLocals returns the expressions of the locals defined in the .tf
+// files of the configuration directory. They are read once per run.
+func (app *App) directoryLocals() (map[string]hcl.Expression, error) {
+	if app.locals != nil {
+		return app.locals, nil
+	}
+	app.locals = map[string]hcl.Expression{}
+	if app.CLI == nil || app.CLI.Dir == "" {
+		return app.locals, nil
+	}
+	files, err := app.configFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		hclFile, diags := hclparse.NewParser().ParseHCL(data, file)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("error parsing HCL: %s", diags)
+		}
+		body, ok := hclFile.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		for name, expr := range localExpressions(body) {
+			app.locals[name] = expr
+		}
+	}
+	return app.locals, nil
+}
+
+func localExpressions(body *hclsyntax.Body) map[string]hcl.Expression {
+	exprs := map[string]hcl.Expression{}
+	for _, block := range body.Blocks {
+		if block.Type != "locals" {
+			continue
+		}
+		for name, attr := range block.Body.Attributes {
+			exprs[name] = attr.Expr
+		}
+	}
+	return exprs
+}
+
+// evaluateLocals evaluates locals that may refer to each other. It keeps
+// evaluating the remaining locals while any of them becomes known, so the
+// order of definition does not matter. Locals that never become known are
+// returned as unknown values.
+func evaluateLocals(exprs map[string]hcl.Expression) map[string]cty.Value {
+	values := make(map[string]cty.Value, len(exprs))
+	for name := range exprs {
+		values[name] = cty.DynamicVal
+	}
+	resolved := map[string]bool{}
+	for progress := true; progress; {
+		progress = false
+		snapshot := make(map[string]cty.Value, len(values))
+		for name, value := range values {
+			snapshot[name] = value
+		}
+		ctx := &hcl.EvalContext{
+			Variables: map[string]cty.Value{"local": cty.ObjectVal(snapshot)},
+			Functions: forEachFunctions,
+		}
+		for name, expr := range exprs {
+			if resolved[name] {
+				continue
+			}
+			value, diags := expr.Value(ctx)
+			if diags.HasErrors() || !value.IsWhollyKnown() {
+				continue
+			}
+			values[name] = value
+			resolved[name] = true
+			progress = true
+		}
+	}
+	return values
+}
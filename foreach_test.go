@@ -0,0 +1,201 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+func TestApp_applyAllDeletions_importForEach(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		state       string
+		wantRemoved bool
+		wantErr     string
+	}{
+		{
+			name: "literal map: all targets in state",
+			data: `
+import {
+  for_each = { a = "id-a", b = "id-b" }
+  id       = each.value
+  to       = time_static.web[each.key]
+}
+`,
+			state:       stateWithInstances("web", `"a"`, `"b"`),
+			wantRemoved: true,
+		},
+		{
+			name: "literal map: one target missing",
+			data: `
+import {
+  for_each = { a = "id-a", b = "id-b" }
+  id       = each.value
+  to       = time_static.web[each.key]
+}
+`,
+			state:       stateWithInstances("web", `"a"`),
+			wantRemoved: false,
+		},
+		{
+			name: "set from toset",
+			data: `
+import {
+  for_each = toset(["a", "b"])
+  id       = each.key
+  to       = time_static.web[each.key]
+}
+`,
+			state:       stateWithInstances("web", `"a"`, `"b"`),
+			wantRemoved: true,
+		},
+		{
+			name: "locals referring to each other",
+			data: `
+locals {
+  buckets = merge(local.base, { b = "id-b" })
+  base    = { a = "id-a" }
+}
+
+import {
+  for_each = local.buckets
+  id       = each.value
+  to       = time_static.web[each.key]
+}
+`,
+			state:       stateWithInstances("web", `"a"`),
+			wantRemoved: false,
+		},
+		{
+			name: "key built from each.value",
+			data: `
+import {
+  for_each = { first = "a" }
+  id       = each.value
+  to       = time_static.web[each.value]
+}
+`,
+			state:       stateWithInstances("web", `"a"`),
+			wantRemoved: true,
+		},
+		{
+			name: "for_each over a variable",
+			data: `
+import {
+  for_each = var.buckets
+  id       = each.value
+  to       = time_static.web[each.key]
+}
+`,
+			state:   stateWithInstances("web", `"a"`),
+			wantErr: "memory.tf:3: cannot evaluate for_each of import block",
+		},
+		{
+			name: "for_each over a local that refers to a variable",
+			data: `
+locals {
+  buckets = var.buckets
+}
+
+import {
+  for_each = local.buckets
+  id       = each.value
+  to       = time_static.web[each.key]
+}
+`,
+			state:   stateWithInstances("web", `"a"`),
+			wantErr: "only known to Terraform",
+		},
+		{
+			name: "for_each over a list",
+			data: `
+import {
+  for_each = ["a"]
+  id       = each.value
+  to       = time_static.web[each.key]
+}
+`,
+			state:   stateWithInstances("web", `"a"`),
+			wantErr: "must be a map or a set of strings",
+		},
+		{
+			name: "each.key without for_each",
+			data: `
+import {
+  id = "id-a"
+  to = time_static.web[each.key]
+}
+`,
+			state:   stateWithInstances("web", `"a"`),
+			wantErr: "memory.tf:4: cannot resolve to of import block",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := tfstate.Read(t.Context(), strings.NewReader(tt.state))
+			if err != nil {
+				t.Fatal(err)
+			}
+			states := []*tfstate.TFState{state}
+			app := &App{hclParser: hclparse.NewParser(), CLI: &CLI{Strict: tt.wantErr != ""}}
+			got, err := app.applyAllDeletions([]byte(tt.data), states)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyAllDeletions() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				// Without --strict the block is reported and kept.
+				app := &App{hclParser: hclparse.NewParser(), CLI: &CLI{}}
+				got, err := app.applyAllDeletions([]byte(tt.data), states)
+				if err != nil {
+					t.Fatalf("applyAllDeletions() error = %v", err)
+				}
+				if string(got) != tt.data {
+					t.Errorf("applyAllDeletions() got = %q, want %q", got, tt.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyAllDeletions() error = %v", err)
+			}
+			if tt.wantRemoved {
+				if strings.Contains(string(got), "import {") {
+					t.Errorf("applyAllDeletions() kept the import block: %q", got)
+				}
+			} else if string(got) != tt.data {
+				t.Errorf("applyAllDeletions() got = %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestApp_Run_importForEachDirectoryLocals(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"locals.tf": "locals {\n  buckets = toset([\"a\", \"b\"])\n}\n",
+		"imports.tf": `import {
+  for_each = local.buckets
+  id       = each.key
+  to       = time_static.web[each.key]
+}
+`,
+		"terraform.tfstate": stateWithInstances("web", `"a"`, `"b"`),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write fixture: %v", err)
+		}
+	}
+	app := New(&CLI{Dir: dir, Tfstate: []string{filepath.Join(dir, "terraform.tfstate")}})
+	if err := app.Run(t.Context()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "imports.tf")); !os.IsNotExist(err) {
+		t.Errorf("imports.tf should be removed once every target is imported, stat error = %v", err)
+	}
+}
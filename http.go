@@ -0,0 +1,99 @@
+package tfclean
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// httpConfig is the state location of an http backend.
+type httpConfig struct {
+	address  string
+	username string
+	password string
+}
+
+// buildHTTPConfig reads a backend "http" block. As in Terraform, address,
+// username and password fall back to TF_HTTP_ADDRESS, TF_HTTP_USERNAME and
+// TF_HTTP_PASSWORD when they are not set in the block.
+func (app *App) buildHTTPConfig(backendBlock *hclsyntax.Block) (httpConfig, error) {
+	var cfg httpConfig
+	for _, target := range []struct {
+		name  string
+		env   string
+		value *string
+	}{
+		{"address", "TF_HTTP_ADDRESS", &cfg.address},
+		{"username", "TF_HTTP_USERNAME", &cfg.username},
+		{"password", "TF_HTTP_PASSWORD", &cfg.password},
+	} {
+		if _, ok := backendBlock.Body.Attributes[target.name]; !ok {
+			*target.value = os.Getenv(target.env)
+			continue
+		}
+		value, err := app.getStringAttribute(backendBlock.Body, target.name)
+		if err != nil {
+			return httpConfig{}, fmt.Errorf("http backend: %w", err)
+		}
+		*target.value = value
+	}
+	if cfg.address == "" {
+		return httpConfig{}, fmt.Errorf("http backend: address attribute or TF_HTTP_ADDRESS is required")
+	}
+	u, err := url.Parse(cfg.address)
+	if err != nil {
+		return httpConfig{}, fmt.Errorf("http backend: invalid address: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return httpConfig{}, fmt.Errorf("http backend: address must be an http or https URL")
+	}
+	return cfg, nil
+}
+
+// httpSource reads the state of an http backend. It is named after the
+// address, without any credentials in it.
+func (app *App) httpSource(cfg httpConfig) stateSource {
+	name := cfg.address
+	if u, err := url.Parse(cfg.address); err == nil {
+		name = u.Redacted()
+	}
+	return stateSource{
+		name: name,
+		read: func(ctx context.Context) (*tfstate.TFState, error) {
+			return app.readHTTPState(ctx, cfg)
+		},
+	}
+}
+
+// readHTTPState fetches the state with a GET request, as Terraform does. Any
+// response but 200 is an error: reading a missing state as empty could let
+// blocks that are still pending elsewhere be removed.
+func (app *App) readHTTPState(ctx context.Context, cfg httpConfig) (*tfstate.TFState, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.address, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.username != "" || cfg.password != "" {
+		req.SetBasicAuth(cfg.username, cfg.password)
+	}
+	client := app.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected response %s: %s", resp.Status, body)
+	}
+	return readState(ctx, resp.Body)
+}
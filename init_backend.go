@@ -0,0 +1,132 @@
+package tfclean
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// dataDir returns the directory terraform init writes to, .terraform in the
+// configuration directory unless TF_DATA_DIR says otherwise.
+func (app *App) dataDir() string {
+	if dir := os.Getenv("TF_DATA_DIR"); dir != "" {
+		if filepath.IsAbs(dir) {
+			return dir
+		}
+		return filepath.Join(app.CLI.Dir, dir)
+	}
+	return filepath.Join(app.CLI.Dir, ".terraform")
+}
+
+// initializedBackend returns the backend terraform init recorded in
+// .terraform/terraform.tfstate, as a backend block (or a cloud block) holding
+// the resolved settings, partial configuration included. It returns nil when
+// the directory has not been initialised with a backend.
+func (app *App) initializedBackend() (*hclsyntax.Block, error) {
+	path := filepath.Join(app.dataDir(), "terraform.tfstate")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Backend *struct {
+			Type   string          `json:"type"`
+			Config json.RawMessage `json:"config"`
+		} `json:"backend"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if doc.Backend == nil || doc.Backend.Type == "" {
+		return nil, nil
+	}
+
+	body := &hclsyntax.Body{Attributes: hclsyntax.Attributes{}}
+	if len(doc.Backend.Config) > 0 && string(doc.Backend.Config) != "null" {
+		ty, err := ctyjson.ImpliedType(doc.Backend.Config)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing backend config in %s: %w", path, err)
+		}
+		config, err := ctyjson.Unmarshal(doc.Backend.Config, ty)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing backend config in %s: %w", path, err)
+		}
+		if !config.Type().IsObjectType() {
+			return nil, fmt.Errorf("%s: backend config is not an object", path)
+		}
+		body = configBody(config)
+		// Nested settings such as assume_role or workspaces are written
+		// as objects; the backend readers expect them as blocks.
+		for _, name := range sortedAttributeNames(config) {
+			value := config.GetAttr(name)
+			if value.IsNull() || !value.Type().IsObjectType() {
+				continue
+			}
+			delete(body.Attributes, name)
+			body.Blocks = append(body.Blocks, &hclsyntax.Block{Type: name, Body: configBody(value)})
+		}
+	}
+
+	if doc.Backend.Type == "cloud" {
+		return &hclsyntax.Block{Type: "cloud", Body: body}, nil
+	}
+	return &hclsyntax.Block{Type: "backend", Labels: []string{doc.Backend.Type}, Body: body}, nil
+}
+
+// configBody returns the non-null attributes of an object as a body.
+func configBody(obj cty.Value) *hclsyntax.Body {
+	body := &hclsyntax.Body{Attributes: hclsyntax.Attributes{}}
+	for name, value := range obj.AsValueMap() {
+		if value.IsNull() {
+			continue
+		}
+		body.Attributes[name] = &hclsyntax.Attribute{Name: name, Expr: &hclsyntax.LiteralValueExpr{Val: value}}
+	}
+	return body
+}
+
+func sortedAttributeNames(obj cty.Value) []string {
+	var names []string
+	for name := range obj.Type().AttributeTypes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// blockBackendType returns the backend type of a backend or cloud block, in
+// the form terraform init records it.
+func blockBackendType(block *hclsyntax.Block) string {
+	if block.Type == "cloud" {
+		return "cloud"
+	}
+	if len(block.Labels) > 0 {
+		return block.Labels[0]
+	}
+	return ""
+}
+
+// currentWorkspace returns the workspace Terraform would use: TF_WORKSPACE,
+// then the one selected with terraform workspace select, then default.
+func (app *App) currentWorkspace() string {
+	if ws := os.Getenv("TF_WORKSPACE"); ws != "" {
+		return ws
+	}
+	if data, err := os.ReadFile(filepath.Join(app.dataDir(), "environment")); err == nil {
+		if ws := strings.TrimSpace(string(data)); ws != "" {
+			return ws
+		}
+	}
+	return "default"
+}
@@ -0,0 +1,238 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApp_initializedBackend_s3(t *testing.T) {
+	t.Setenv("TF_DATA_DIR", "")
+	dir := t.TempDir()
+	writeInitState(t, dir, `{
+  "version": 3,
+  "serial": 1,
+  "backend": {
+    "type": "s3",
+    "config": {
+      "bucket": "prod-states",
+      "key": "app/terraform.tfstate",
+      "region": "eu-west-1",
+      "profile": null,
+      "use_path_style": true,
+      "workspace_key_prefix": null,
+      "assume_role": {
+        "role_arn": "arn:aws:iam::111111111111:role/state-reader",
+        "external_id": null,
+        "session_name": null
+      },
+      "endpoints": null
+    },
+    "hash": 1234
+  }
+}`)
+
+	app := New(&CLI{Dir: dir})
+	block, err := app.initializedBackend()
+	if err != nil {
+		t.Fatalf("initializedBackend() error = %v", err)
+	}
+	if block == nil || blockBackendType(block) != "s3" {
+		t.Fatalf("initializedBackend() = %v, want an s3 backend block", block)
+	}
+	got, err := app.buildS3Config(block)
+	if err != nil {
+		t.Fatalf("buildS3Config() error = %v", err)
+	}
+	want := s3Config{
+		bucket:             "prod-states",
+		key:                "app/terraform.tfstate",
+		region:             "eu-west-1",
+		roleARN:            "arn:aws:iam::111111111111:role/state-reader",
+		usePathStyle:       true,
+		workspaceKeyPrefix: "env:",
+	}
+	if got != want {
+		t.Errorf("buildS3Config() = %+v, want %+v", got, want)
+	}
+}
+
+func TestApp_initializedBackend_notInitialized(t *testing.T) {
+	t.Setenv("TF_DATA_DIR", "")
+	for name, content := range map[string]string{
+		"no .terraform directory": "",
+		"no backend recorded":     `{"version": 3, "serial": 1, "modules": []}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if content != "" {
+				writeInitState(t, dir, content)
+			}
+			block, err := New(&CLI{Dir: dir}).initializedBackend()
+			if err != nil || block != nil {
+				t.Errorf("initializedBackend() = %v, %v, want nil, nil", block, err)
+			}
+		})
+	}
+}
+
+func TestApp_currentWorkspace(t *testing.T) {
+	tests := []struct {
+		name        string
+		tfWorkspace string
+		tfDataDir   string
+		environment map[string]string
+		want        string
+	}{
+		{name: "default", want: "default"},
+		{
+			name:        "selected workspace",
+			environment: map[string]string{".terraform/environment": "staging\n"},
+			want:        "staging",
+		},
+		{
+			name:        "TF_WORKSPACE wins",
+			tfWorkspace: "prod",
+			environment: map[string]string{".terraform/environment": "staging\n"},
+			want:        "prod",
+		},
+		{
+			name:        "TF_DATA_DIR",
+			tfDataDir:   "data",
+			environment: map[string]string{".terraform/environment": "staging\n", "data/environment": "qa"},
+			want:        "qa",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TF_WORKSPACE", tt.tfWorkspace)
+			t.Setenv("TF_DATA_DIR", tt.tfDataDir)
+			dir := t.TempDir()
+			for name, content := range tt.environment {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := New(&CLI{Dir: dir}).currentWorkspace(); got != tt.want {
+				t.Errorf("currentWorkspace() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_detectBackendFromConfig_initialized(t *testing.T) {
+	tests := []struct {
+		name      string
+		backendTF string
+		initState string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "partial backend block",
+			backendTF: "terraform {\n  backend \"local\" {\n    path = 1\n  }\n}\n",
+			initState: `{"backend": {"type": "local", "config": {"path": "states/dev.tfstate", "workspace_dir": null}}}`,
+			want:      "states/dev.tfstate",
+		},
+		{
+			name:      "no backend block",
+			initState: `{"backend": {"type": "local", "config": {"path": "states/dev.tfstate"}}}`,
+			want:      "states/dev.tfstate",
+		},
+		{
+			name:      "complete backend block wins",
+			backendTF: "terraform {\n  backend \"local\" {\n    path = \"states/prod.tfstate\"\n  }\n}\n",
+			initState: `{"backend": {"type": "local", "config": {"path": "states/dev.tfstate"}}}`,
+			want:      "states/prod.tfstate",
+		},
+		{
+			name:      "initialised with another backend",
+			backendTF: "terraform {\n  backend \"s3\" {}\n}\n",
+			initState: `{"backend": {"type": "local", "config": {"path": "states/dev.tfstate"}}}`,
+			wantErr:   "bucket attribute is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TF_DATA_DIR", "")
+			dir := t.TempDir()
+			if tt.backendTF != "" {
+				if err := os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(tt.backendTF), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeInitState(t, dir, tt.initState)
+
+			sources, err := New(&CLI{Dir: dir}).detectBackendFromConfig(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("detectBackendFromConfig() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectBackendFromConfig() error = %v", err)
+			}
+			if got, want := sourceNames(sources), filepath.Join(dir, tt.want); got != want {
+				t.Errorf("detectBackendFromConfig() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_initializedS3Backend(t *testing.T) {
+	isolateAWSEnv(t)
+	t.Setenv("TF_DATA_DIR", "")
+	server := newFakeS3(t, map[string]string{
+		"prod-states/app/terraform.tfstate":              stateWithResource("aaa"),
+		"prod-states/env:/staging/app/terraform.tfstate": stateWithResource("bbb"),
+	})
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"backend.tf": "terraform {\n  backend \"s3\" {}\n}\n",
+		"main.tf":    "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeInitState(t, dir, `{"backend": {"type": "s3", "config": {
+  "bucket": "prod-states",
+  "key": "app/terraform.tfstate",
+  "region": "eu-west-1",
+  "use_path_style": true,
+  "endpoints": {"s3": "`+server.URL+`", "sts": null}
+}}}`)
+	if err := os.WriteFile(filepath.Join(dir, ".terraform", "environment"), []byte("staging"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := New(&CLI{Dir: dir}).Run(t.Context()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "resource \"time_static\" \"bbb\" {}\n\n"; string(got) != want {
+		t.Errorf("main.tf = %q, want %q", got, want)
+	}
+}
+
+// writeInitState writes .terraform/terraform.tfstate as terraform init does.
+func writeInitState(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, ".terraform"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".terraform", "terraform.tfstate"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package tfclean
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// stateWithInstances returns a minimal Terraform state JSON containing a
+// time_static resource with one instance per index key. Keys are given as
+// JSON values, e.g. `0` or `"a"`.
+func stateWithInstances(name string, keys ...string) string {
+	var instances []string
+	for _, key := range keys {
+		instances = append(instances, `{"index_key": `+key+`, "schema_version": 0, "attributes": {"id": "x"}}`)
+	}
+	return `
+{
+  "version": 4,
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "time_static",
+      "name": "` + name + `",
+      "provider": "provider[\"registry.terraform.io/hashicorp/time\"]",
+      "instances": [` + strings.Join(instances, ",") + `]
+    }
+  ]
+}
+`
+}
+
+func TestApp_applyAllDeletions_resourceInstances(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		state       string
+		wantRemoved bool
+	}{
+		{
+			name:        "removed resource: count instances still in state",
+			data:        "\nremoved {\n  from = time_static.web\n}\n",
+			state:       stateWithInstances("web", `0`, `1`),
+			wantRemoved: false,
+		},
+		{
+			name:        "removed resource: for_each instances still in state",
+			data:        "\nremoved {\n  from = time_static.web\n}\n",
+			state:       stateWithInstances("web", `"a"`),
+			wantRemoved: false,
+		},
+		{
+			name:        "removed resource: only a similarly named resource remains",
+			data:        "\nremoved {\n  from = time_static.web\n}\n",
+			state:       stateWithInstances("web_v2", `0`),
+			wantRemoved: true,
+		},
+		{
+			name:        "whole resource moved: applied",
+			data:        "\nmoved {\n  from = time_static.old\n  to   = time_static.web\n}\n",
+			state:       stateWithInstances("web", `"a"`, `"b"`),
+			wantRemoved: true,
+		},
+		{
+			name:        "whole resource moved: pending",
+			data:        "\nmoved {\n  from = time_static.web\n  to   = time_static.new\n}\n",
+			state:       stateWithInstances("web", `"a"`, `"b"`),
+			wantRemoved: false,
+		},
+		{
+			name:        "instance moved: applied",
+			data:        "\nmoved {\n  from = time_static.web[0]\n  to   = time_static.web[\"a\"]\n}\n",
+			state:       stateWithInstances("web", `"a"`, `1`),
+			wantRemoved: true,
+		},
+		{
+			name:        "instance moved: pending",
+			data:        "\nmoved {\n  from = time_static.web[0]\n  to   = time_static.web[\"a\"]\n}\n",
+			state:       stateWithInstances("web", `0`, `1`),
+			wantRemoved: false,
+		},
+		{
+			name:        "count added to a resource: applied",
+			data:        "\nmoved {\n  from = time_static.web\n  to   = time_static.web[0]\n}\n",
+			state:       stateWithInstances("web", `0`),
+			wantRemoved: true,
+		},
+		{
+			name:        "import into a resource with instances: applied",
+			data:        "\nimport {\n  id = \"x\"\n  to = time_static.web\n}\n",
+			state:       stateWithInstances("web", `0`),
+			wantRemoved: true,
+		},
+		{
+			name:        "import into an instance: pending",
+			data:        "\nimport {\n  id = \"x\"\n  to = time_static.web[1]\n}\n",
+			state:       stateWithInstances("web", `0`),
+			wantRemoved: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := tfstate.Read(t.Context(), strings.NewReader(tt.state))
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := &App{hclParser: hclparse.NewParser(), CLI: &CLI{}}
+			got, err := app.applyAllDeletions([]byte(tt.data), []*tfstate.TFState{state})
+			if err != nil {
+				t.Fatalf("applyAllDeletions() error = %v", err)
+			}
+			want := tt.data
+			if tt.wantRemoved {
+				want = "\n"
+			}
+			if string(got) != want {
+				t.Errorf("applyAllDeletions() got = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package tfclean
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+const (
+	defaultLocalStatePath    = "terraform.tfstate"
+	defaultLocalWorkspaceDir = "terraform.tfstate.d"
+)
+
+// localSources returns the states of every workspace of a local backend: the
+// default workspace at path and the others at
+// <workspace_dir>/<name>/terraform.tfstate. All of them are on disk, so they
+// are always checked together. A workspace without a state file has never
+// been applied and is skipped; when no workspace has one, the default path is
+// returned so that reading it reports the missing file.
+func (app *App) localSources(backendBlock *hclsyntax.Block) ([]stateSource, error) {
+	path, err := app.buildLocalPath(backendBlock)
+	if err != nil {
+		return nil, err
+	}
+	workspaceDir := defaultLocalWorkspaceDir
+	if _, ok := backendBlock.Body.Attributes["workspace_dir"]; ok {
+		if workspaceDir, err = app.getStringAttribute(backendBlock.Body, "workspace_dir"); err != nil {
+			return nil, fmt.Errorf("local backend: %w", err)
+		}
+	}
+	return app.localWorkspaceSources(path, workspaceDir)
+}
+
+// localWorkspaceSources lists the state files of the default workspace at path
+// and of the workspaces under workspaceDir, relative to the configuration
+// directory.
+func (app *App) localWorkspaceSources(path, workspaceDir string) ([]stateSource, error) {
+	if !filepath.IsAbs(workspaceDir) {
+		workspaceDir = filepath.Join(app.CLI.Dir, workspaceDir)
+	}
+	var sources []stateSource
+	if _, err := os.Stat(path); err == nil {
+		sources = append(sources, urlSource(path))
+	}
+	entries, err := os.ReadDir(workspaceDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	// ReadDir returns the entries sorted by name.
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		state := filepath.Join(workspaceDir, entry.Name(), defaultLocalStatePath)
+		if _, err := os.Stat(state); err == nil {
+			sources = append(sources, urlSource(state))
+		}
+	}
+	if len(sources) == 0 {
+		return []stateSource{urlSource(path)}, nil
+	}
+	return sources, nil
+}
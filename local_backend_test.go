@@ -0,0 +1,245 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApp_detectBackendFromConfig_local(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    func(dir string) string
+		wantErr bool
+	}{
+		{
+			name: "local backend with a relative path",
+			files: map[string]string{
+				"backend.tf": "terraform {\n  backend \"local\" {\n    path = \"states/dev.tfstate\"\n  }\n}\n",
+			},
+			want: func(dir string) string { return filepath.Join(dir, "states", "dev.tfstate") },
+		},
+		{
+			name: "local backend with an absolute path",
+			files: map[string]string{
+				"backend.tf": "terraform {\n  backend \"local\" {\n    path = \"/var/lib/terraform/dev.tfstate\"\n  }\n}\n",
+			},
+			want: func(string) string { return "/var/lib/terraform/dev.tfstate" },
+		},
+		{
+			name: "local backend without a path",
+			files: map[string]string{
+				"backend.tf": "terraform {\n  backend \"local\" {}\n}\n",
+			},
+			want: func(dir string) string { return filepath.Join(dir, "terraform.tfstate") },
+		},
+		{
+			name: "local backend with a path that is not a string",
+			files: map[string]string{
+				"backend.tf": "terraform {\n  backend \"local\" {\n    path = [\"a\"]\n  }\n}\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "no backend block and an implicit terraform.tfstate",
+			files: map[string]string{
+				"main.tf":           "resource \"null_resource\" \"test\" {}\n",
+				"terraform.tfstate": stateWithResource("test"),
+			},
+			want: func(dir string) string { return filepath.Join(dir, "terraform.tfstate") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("write fixture: %v", err)
+				}
+			}
+			app := &App{CLI: &CLI{Dir: dir}}
+			sources, err := app.detectBackendFromConfig(t.Context())
+			got := sourceNames(sources)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectBackendFromConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := tt.want(dir); got != want {
+				t.Errorf("detectBackendFromConfig() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_localBackend(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "states"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"backend.tf":         "terraform {\n  backend \"local\" {\n    path = \"states/dev.tfstate\"\n  }\n}\n",
+		"main.tf":            "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n\nremoved {\n  from = time_static.bbb\n}\n",
+		"states/dev.tfstate": stateWithResource("bbb"),
+		"terraform.tfstate":  stateWithResource("aaa"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write fixture: %v", err)
+		}
+	}
+	// No --tfstate: the state comes from the local backend's path, not the
+	// implicit terraform.tfstate.
+	if err := New(&CLI{Dir: dir}).Run(t.Context()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "resource \"time_static\" \"bbb\" {}\n\nremoved {\n  from = time_static.bbb\n}\n"
+	if string(got) != want {
+		t.Errorf("main.tf = %q, want %q", got, want)
+	}
+}
+
+func TestApp_detectBackendFromConfig_localWorkspaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "default and workspace states",
+			files: map[string]string{
+				"backend.tf":        "terraform {\n  backend \"local\" {}\n}\n",
+				"terraform.tfstate": stateWithResource("a"),
+				"terraform.tfstate.d/prod/terraform.tfstate": stateWithResource("a"),
+				"terraform.tfstate.d/dev/terraform.tfstate":  stateWithResource("a"),
+				"terraform.tfstate.d/empty/.keep":            "",
+			},
+			want: []string{"terraform.tfstate", "terraform.tfstate.d/dev/terraform.tfstate", "terraform.tfstate.d/prod/terraform.tfstate"},
+		},
+		{
+			name: "workspace states only",
+			files: map[string]string{
+				"backend.tf": "terraform {\n  backend \"local\" {}\n}\n",
+				"terraform.tfstate.d/dev/terraform.tfstate": stateWithResource("a"),
+			},
+			want: []string{"terraform.tfstate.d/dev/terraform.tfstate"},
+		},
+		{
+			name: "custom path and workspace_dir",
+			files: map[string]string{
+				"backend.tf":                               "terraform {\n  backend \"local\" {\n    path          = \"states/default.tfstate\"\n    workspace_dir = \"states/ws\"\n  }\n}\n",
+				"states/default.tfstate":                   stateWithResource("a"),
+				"states/ws/dev/terraform.tfstate":          stateWithResource("a"),
+				"terraform.tfstate.d/qa/terraform.tfstate": stateWithResource("a"),
+			},
+			want: []string{"states/default.tfstate", "states/ws/dev/terraform.tfstate"},
+		},
+		{
+			name: "no backend block",
+			files: map[string]string{
+				"main.tf": "resource \"null_resource\" \"test\" {}\n",
+				"terraform.tfstate.d/dev/terraform.tfstate": stateWithResource("a"),
+			},
+			want: []string{"terraform.tfstate.d/dev/terraform.tfstate"},
+		},
+		{
+			name: "no state at all",
+			files: map[string]string{
+				"main.tf": "resource \"null_resource\" \"test\" {}\n",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TF_DATA_DIR", "")
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			app := &App{CLI: &CLI{Dir: dir}}
+			sources, err := app.detectBackendFromConfig(t.Context())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectBackendFromConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var want []string
+			for _, name := range tt.want {
+				want = append(want, filepath.Join(dir, name))
+			}
+			if got := sourceNames(sources); got != strings.Join(want, ", ") {
+				t.Errorf("detectBackendFromConfig() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_localWorkspaces(t *testing.T) {
+	const main = "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	tests := []struct {
+		name   string
+		states map[string]string
+		want   string
+	}{
+		{
+			name: "applied in every workspace",
+			states: map[string]string{
+				"terraform.tfstate":                          stateWithResource("bbb"),
+				"terraform.tfstate.d/dev/terraform.tfstate":  stateWithResource("bbb"),
+				"terraform.tfstate.d/prod/terraform.tfstate": stateWithResource("bbb"),
+			},
+			want: "resource \"time_static\" \"bbb\" {}\n\n",
+		},
+		{
+			name: "one workspace still behind",
+			states: map[string]string{
+				"terraform.tfstate":                          stateWithResource("bbb"),
+				"terraform.tfstate.d/dev/terraform.tfstate":  stateWithResource("bbb"),
+				"terraform.tfstate.d/prod/terraform.tfstate": stateWithResource("aaa"),
+			},
+			want: main,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TF_DATA_DIR", "")
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.tf": main})
+			writeFiles(t, dir, tt.states)
+			if err := New(&CLI{Dir: dir}).Run(t.Context()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("main.tf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeFiles writes files relative to dir, creating parent directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write fixture: %v", err)
+		}
+	}
+}
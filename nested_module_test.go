@@ -0,0 +1,157 @@
+package tfclean
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// stateWithModuleResource returns a minimal Terraform state JSON containing a
+// single time_static resource inside the given module instance path.
+func stateWithModuleResource(module string) string {
+	return `
+{
+  "version": 4,
+  "resources": [
+    {
+      "module": ` + jsonString(module) + `,
+      "mode": "managed",
+      "type": "time_static",
+      "name": "this",
+      "provider": "provider[\"registry.terraform.io/hashicorp/time\"]",
+      "instances": [
+        {
+          "schema_version": 0,
+          "attributes": {
+            "id": "2026-05-13T13:49:53Z"
+          }
+        }
+      ]
+    }
+  ]
+}
+`
+}
+
+func jsonString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func TestApp_applyAllDeletions_nestedModules(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		state       string
+		wantRemoved bool
+	}{
+		{
+			name: "nested module moved to the root: applied",
+			data: `
+moved {
+  from = module.platform.module.network
+  to   = module.network
+}
+`,
+			state:       stateWithModuleResource("module.network"),
+			wantRemoved: true,
+		},
+		{
+			name: "nested module moved to the root: pending",
+			data: `
+moved {
+  from = module.platform.module.network
+  to   = module.network
+}
+`,
+			state:       stateWithModuleResource("module.platform.module.network"),
+			wantRemoved: false,
+		},
+		{
+			name: "nested module with keys moved: pending",
+			data: `
+moved {
+  from = module.platform["a"].module.network
+  to   = module.network["a"]
+}
+`,
+			state:       stateWithModuleResource(`module.platform["a"].module.network`),
+			wantRemoved: false,
+		},
+		{
+			name: "nested module with keys moved: applied",
+			data: `
+moved {
+  from = module.platform["a"].module.network
+  to   = module.network["a"]
+}
+`,
+			state:       stateWithModuleResource(`module.network["a"]`),
+			wantRemoved: true,
+		},
+		{
+			name: "whole module call moved: instances with keys still under from",
+			data: `
+moved {
+  from = module.old
+  to   = module.new
+}
+`,
+			state:       stateWithModuleResource(`module.old[0]`),
+			wantRemoved: false,
+		},
+		{
+			name: "single instance moved into a keyed instance: applied",
+			data: `
+moved {
+  from = module.app
+  to   = module.app["a"]
+}
+`,
+			state:       stateWithModuleResource(`module.app["a"]`),
+			wantRemoved: true,
+		},
+		{
+			name: "removed nested module: instances still in state",
+			data: `
+removed {
+  from = module.platform.module.network
+}
+`,
+			state:       stateWithModuleResource(`module.platform["a"].module.network["b"]`),
+			wantRemoved: false,
+		},
+		{
+			name: "removed nested module: only a similarly named module remains",
+			data: `
+removed {
+  from = module.platform.module.network
+}
+`,
+			state:       stateWithModuleResource("module.platform.module.network_v2"),
+			wantRemoved: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := tfstate.Read(t.Context(), strings.NewReader(tt.state))
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := &App{hclParser: hclparse.NewParser(), CLI: &CLI{}}
+			got, err := app.applyAllDeletions([]byte(tt.data), []*tfstate.TFState{state})
+			if err != nil {
+				t.Fatalf("applyAllDeletions() error = %v", err)
+			}
+			want := tt.data
+			if tt.wantRemoved {
+				want = "\n"
+			}
+			if string(got) != want {
+				t.Errorf("applyAllDeletions() got = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package tfclean
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/lib/pq"
+)
+
+const defaultPGSchemaName = "terraform_remote_state"
+
+// pgConfig is the state location of a pg backend.
+type pgConfig struct {
+	connStr    string
+	schemaName string
+}
+
+// buildPGConfig reads a backend "pg" block. As in Terraform, conn_str and
+// schema_name fall back to PG_CONN_STR and PG_SCHEMA_NAME.
+func (app *App) buildPGConfig(backendBlock *hclsyntax.Block) (pgConfig, error) {
+	var cfg pgConfig
+	for _, target := range []struct {
+		name  string
+		env   string
+		value *string
+	}{
+		{"conn_str", "PG_CONN_STR", &cfg.connStr},
+		{"schema_name", "PG_SCHEMA_NAME", &cfg.schemaName},
+	} {
+		if _, ok := backendBlock.Body.Attributes[target.name]; !ok {
+			*target.value = os.Getenv(target.env)
+			continue
+		}
+		value, err := app.getStringAttribute(backendBlock.Body, target.name)
+		if err != nil {
+			return pgConfig{}, fmt.Errorf("pg backend: %w", err)
+		}
+		*target.value = value
+	}
+	if cfg.connStr == "" {
+		return pgConfig{}, fmt.Errorf("pg backend: conn_str attribute or PG_CONN_STR is required")
+	}
+	if cfg.schemaName == "" {
+		cfg.schemaName = defaultPGSchemaName
+	}
+	return cfg, nil
+}
+
+// pgSource reads the state of workspace ws from a pg backend. The backend
+// keeps one row per workspace in <schema_name>.states.
+func pgSource(cfg pgConfig, ws string) stateSource {
+	return stateSource{
+		name: fmt.Sprintf("pg://%s/%s.states/%s", redactConnStr(cfg.connStr), cfg.schemaName, ws),
+		read: func(ctx context.Context) (*tfstate.TFState, error) {
+			return readPGState(ctx, cfg, ws)
+		},
+	}
+}
+
+// pgWorkspaceSources returns the states of every workspace of a pg backend,
+// one per row of <schema_name>.states, in the order of their names.
+func pgWorkspaceSources(ctx context.Context, cfg pgConfig) ([]stateSource, error) {
+	db, err := sql.Open("postgres", cfg.connStr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := fmt.Sprintf("SELECT name, data FROM %s.states ORDER BY name", pq.QuoteIdentifier(cfg.schemaName))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not list workspaces in %s.states: %w", cfg.schemaName, err)
+	}
+	defer rows.Close()
+	var sources []stateSource
+	for rows.Next() {
+		var ws, data string
+		if err := rows.Scan(&ws, &data); err != nil {
+			return nil, fmt.Errorf("could not list workspaces in %s.states: %w", cfg.schemaName, err)
+		}
+		source := pgSource(cfg, ws)
+		source.read = func(ctx context.Context) (*tfstate.TFState, error) {
+			return readState(ctx, strings.NewReader(data))
+		}
+		sources = append(sources, source)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list workspaces in %s.states: %w", cfg.schemaName, err)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no workspace state found in %s.states", cfg.schemaName)
+	}
+	return sources, nil
+}
+
+func readPGState(ctx context.Context, cfg pgConfig, ws string) (*tfstate.TFState, error) {
+	db, err := sql.Open("postgres", cfg.connStr)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := fmt.Sprintf("SELECT data FROM %s.states WHERE name = $1", pq.QuoteIdentifier(cfg.schemaName))
+	var data string
+	err = db.QueryRowContext(ctx, query, ws).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no state for workspace %s in %s.states", ws, cfg.schemaName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readState(ctx, strings.NewReader(data))
+}
+
+// redactConnStr returns a connection string that is safe to show: the host and
+// database of a URL, or the host of a key/value string, without credentials.
+func redactConnStr(connStr string) string {
+	if u, err := url.Parse(connStr); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Host + u.Path
+	}
+	var host, dbname string
+	for _, field := range strings.Fields(connStr) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "host":
+			host = value
+		case "dbname":
+			dbname = value
+		}
+	}
+	if dbname != "" {
+		return host + "/" + dbname
+	}
+	return host
+}
@@ -0,0 +1,170 @@
+package tfclean
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// savedPlan is the part of terraform show -json <planfile> that tells whether
+// Terraform still acts on a moved, import or removed block.
+type savedPlan struct {
+	changes []plannedChange
+}
+
+// plannedChange is one entry of resource_changes.
+type plannedChange struct {
+	name    string
+	address address
+	// previous is set when the plan moves the resource from another address.
+	previous     *address
+	previousName string
+	importing    bool
+	forget       bool
+}
+
+// readPlan reads the JSON of a saved plan. A plan that errored is rejected, as
+// it does not show every action Terraform would take.
+func readPlan(path string) (*savedPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		FormatVersion   string          `json:"format_version"`
+		Errored         bool            `json:"errored"`
+		PlannedValues   json.RawMessage `json:"planned_values"`
+		ResourceChanges []struct {
+			Address         string `json:"address"`
+			PreviousAddress string `json:"previous_address"`
+			Change          struct {
+				Actions   []string        `json:"actions"`
+				Importing json.RawMessage `json:"importing"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	if doc.FormatVersion == "" || (doc.PlannedValues == nil && doc.ResourceChanges == nil) {
+		return nil, fmt.Errorf("not the JSON of a plan; create it with terraform show -json <planfile>")
+	}
+	if doc.Errored {
+		return nil, fmt.Errorf("the plan errored, so it does not show every planned action")
+	}
+
+	p := &savedPlan{}
+	for _, rc := range doc.ResourceChanges {
+		addr, err := parseAddressString(rc.Address)
+		if err != nil {
+			return nil, fmt.Errorf("resource change %s: %w", rc.Address, err)
+		}
+		change := plannedChange{
+			name:      rc.Address,
+			address:   addr,
+			importing: rc.Change.Importing != nil && string(rc.Change.Importing) != "null",
+			forget:    slices.Contains(rc.Change.Actions, "forget"),
+		}
+		if rc.PreviousAddress != "" {
+			previous, err := parseAddressString(rc.PreviousAddress)
+			if err != nil {
+				return nil, fmt.Errorf("resource change %s: previous address %s: %w", rc.Address, rc.PreviousAddress, err)
+			}
+			change.previous, change.previousName = &previous, rc.PreviousAddress
+		}
+		p.changes = append(p.changes, change)
+	}
+	return p, nil
+}
+
+// planEvidence decides a block from the plan instead of a state. The block
+// counts as applied when the plan has no move from its from address, no
+// import of its to addresses or no forget under its from address, for moved,
+// import and removed blocks respectively. Addresses are matched as in the
+// state checks.
+func (app *App) planEvidence(p *savedPlan, blockType string, from address, to []address) blockEvidence {
+	switch blockType {
+	case "import":
+		var evidence blockEvidence
+		pending := 0
+		for _, target := range to {
+			lookup := p.lookup("to", target, func(c plannedChange) (string, bool) {
+				return c.name + " (import)", c.importing && target.matchesResource(c.address, anyKeyLastStep)
+			})
+			evidence.lookups = append(evidence.lookups, lookup)
+			if lookup.found {
+				pending++
+			}
+		}
+		evidence.applied = pending == 0
+		switch {
+		case len(to) == 1 && evidence.applied:
+			evidence.rule = "the plan does not import to, so the import has been applied"
+		case len(to) == 1:
+			evidence.rule = "the plan imports to, so the import is pending"
+		case evidence.applied:
+			evidence.rule = fmt.Sprintf("the plan imports none of the %d for_each targets, so the import has been applied", len(to))
+		default:
+			evidence.rule = fmt.Sprintf("the plan imports %d of %d for_each targets, so the import is pending", pending, len(to))
+		}
+		return evidence
+	case "moved":
+		var matches func(c plannedChange) bool
+		if from.isModule() && len(to) > 0 && to[0].isModule() {
+			match := exactKeys
+			if from.modules[len(from.modules)-1].key == nil && to[0].modules[len(to[0].modules)-1].key == nil {
+				match = anyKeyLastStep
+			}
+			matches = func(c plannedChange) bool { return from.containsModuleOf(*c.previous, match) }
+		} else {
+			match := exactKeys
+			if from.resource != nil && len(to) > 0 && to[0].resource != nil && from.resource.key == nil && to[0].resource.key == nil {
+				match = anyKeyLastStep
+			}
+			matches = func(c plannedChange) bool { return from.matchesResource(*c.previous, match) }
+		}
+		lookup := p.lookup("from", from, func(c plannedChange) (string, bool) {
+			if c.previous == nil {
+				return "", false
+			}
+			return c.name + " (moved from " + c.previousName + ")", matches(c)
+		})
+		evidence := blockEvidence{applied: !lookup.found, lookups: []addressLookup{lookup}}
+		if lookup.found {
+			evidence.rule = "the plan still moves resources from from, so the move is pending"
+		} else {
+			evidence.rule = "the plan moves nothing from from, so the move has been applied"
+		}
+		return evidence
+	default:
+		lookup := p.lookup("from", from, func(c plannedChange) (string, bool) {
+			if !c.forget {
+				return "", false
+			}
+			if from.isModule() {
+				return c.name + " (forget)", from.containsModuleOf(c.address, anyKeyAllSteps)
+			}
+			return c.name + " (forget)", from.matchesResource(c.address, anyKeyAllSteps)
+		})
+		evidence := blockEvidence{applied: !lookup.found, lookups: []addressLookup{lookup}}
+		if lookup.found {
+			evidence.rule = "the plan still forgets resources under from, so the removal is pending"
+		} else {
+			evidence.rule = "the plan forgets nothing under from, so the removal has been applied"
+		}
+		return evidence
+	}
+}
+
+// lookup collects the planned changes that act on addr, described by match.
+func (p *savedPlan) lookup(role string, addr address, match func(plannedChange) (string, bool)) addressLookup {
+	lookup := addressLookup{role: role, address: addr.String(), planned: true}
+	for _, change := range p.changes {
+		if desc, ok := match(change); ok {
+			lookup.matches = append(lookup.matches, desc)
+		}
+	}
+	lookup.found = len(lookup.matches) > 0
+	return lookup
+}
@@ -0,0 +1,244 @@
+package tfclean
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const savedPlanJSON = `{
+  "format_version": "1.2",
+  "terraform_version": "1.9.0",
+  "planned_values": {"root_module": {}},
+  "resource_changes": [
+    {
+      "address": "aws_instance.new",
+      "previous_address": "aws_instance.old",
+      "mode": "managed", "type": "aws_instance", "name": "new",
+      "change": {"actions": ["no-op"]}
+    },
+    {
+      "address": "module.b.aws_vpc.this",
+      "previous_address": "module.a.aws_vpc.this",
+      "module_address": "module.b",
+      "mode": "managed", "type": "aws_vpc", "name": "this",
+      "change": {"actions": ["update"]}
+    },
+    {
+      "address": "aws_s3_bucket.logs[\"x\"]",
+      "mode": "managed", "type": "aws_s3_bucket", "name": "logs", "index": "x",
+      "change": {"actions": ["no-op"], "importing": {"id": "logs-x"}}
+    },
+    {
+      "address": "aws_iam_role.legacy",
+      "mode": "managed", "type": "aws_iam_role", "name": "legacy",
+      "change": {"actions": ["forget"]}
+    },
+    {
+      "address": "time_static.bbb",
+      "mode": "managed", "type": "time_static", "name": "bbb",
+      "change": {"actions": ["no-op"]}
+    }
+  ]
+}`
+
+const planMain = `moved {
+  from = aws_instance.old
+  to   = aws_instance.new
+}
+
+moved {
+  from = time_static.zzz
+  to   = time_static.yyy
+}
+
+moved {
+  from = module.a
+  to   = module.b
+}
+
+moved {
+  from = module.c
+  to   = module.d
+}
+
+import {
+  to = aws_s3_bucket.logs["x"]
+  id = "logs-x"
+}
+
+import {
+  to = aws_s3_bucket.done
+  id = "done"
+}
+
+removed {
+  from = aws_iam_role.legacy
+}
+
+removed {
+  from = aws_iam_role.gone
+}
+`
+
+func TestApp_Run_plan(t *testing.T) {
+	dir := t.TempDir()
+	planPath := filepath.Join(dir, "plan.json")
+	writeFiles(t, dir, map[string]string{"main.tf": planMain, "plan.json": savedPlanJSON})
+	var stdout bytes.Buffer
+	app := New(&CLI{Dir: dir, Plan: planPath, Report: "json"})
+	app.stdout = &stdout
+	if err := app.Run(t.Context()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The vacuous move of time_static.zzz, which the state check would keep,
+	// is removed because the plan does not move anything for it.
+	want := `moved {
+  from = aws_instance.old
+  to   = aws_instance.new
+}
+
+moved {
+  from = module.a
+  to   = module.b
+}
+
+import {
+  to = aws_s3_bucket.logs["x"]
+  id = "logs-x"
+}
+
+removed {
+  from = aws_iam_role.legacy
+}
+
+`
+	if string(got) != want {
+		t.Errorf("main.tf = %q, want %q", got, want)
+	}
+
+	var r report
+	if err := json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		t.Fatalf("invalid report: %v\n%s", err, stdout.String())
+	}
+	if len(r.States) != 1 || r.States[0] != planPath {
+		t.Errorf("report states = %q, want [%q]", r.States, planPath)
+	}
+	var decisions []string
+	for _, block := range r.Blocks {
+		decisions = append(decisions, string(block.Decision))
+	}
+	if got, want := strings.Join(decisions, ","), "kept,removed,kept,removed,kept,removed,kept,removed"; got != want {
+		t.Errorf("decisions = %s, want %s", got, want)
+	}
+}
+
+func TestApp_Run_planErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    string
+		cli     CLI
+		wantErr string
+	}{
+		{
+			name:    "state instead of a plan",
+			plan:    stateWithResource("bbb"),
+			wantErr: "not the JSON of a plan",
+		},
+		{
+			name:    "errored plan",
+			plan:    `{"format_version": "1.2", "errored": true, "resource_changes": []}`,
+			wantErr: "the plan errored",
+		},
+		{
+			name:    "invalid JSON",
+			plan:    "not json",
+			wantErr: "invalid json",
+		},
+		{
+			name:    "combined with --tfstate",
+			plan:    savedPlanJSON,
+			cli:     CLI{Tfstate: []string{"terraform.tfstate"}},
+			wantErr: "--plan cannot be combined with --tfstate",
+		},
+		{
+			name:    "combined with --all",
+			plan:    savedPlanJSON,
+			cli:     CLI{All: true},
+			wantErr: "--all cannot be combined with --plan",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.tf": planMain, "plan.json": tt.plan})
+			cli := tt.cli
+			cli.Dir = dir
+			cli.Plan = filepath.Join(dir, "plan.json")
+			err := New(&cli).Run(t.Context())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != planMain {
+				t.Errorf("main.tf was modified: %q", got)
+			}
+		})
+	}
+}
+
+func TestApp_Explain_plan(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.tf")
+	planPath := filepath.Join(dir, "plan.json")
+	writeFiles(t, dir, map[string]string{"main.tf": planMain, "plan.json": savedPlanJSON})
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{
+			target: "module.a",
+			want: path + `:11-14: moved from=module.a to=module.b
+  plan ` + planPath + `: pending
+    from module.a: 1 planned change(s)
+           module.b.aws_vpc.this (moved from module.a.aws_vpc.this)
+    rule: the plan still moves resources from from, so the move is pending
+  decision: kept (the plan still acts on the block)
+`,
+		},
+		{
+			target: "aws_s3_bucket.done",
+			want: path + `:26-29: import to=aws_s3_bucket.done
+  plan ` + planPath + `: applied
+    to   aws_s3_bucket.done: no planned change
+    rule: the plan does not import to, so the import has been applied
+  decision: removed (no planned action for the block)
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var stdout bytes.Buffer
+			app := New(&CLI{Dir: dir, Plan: planPath})
+			app.stdout = &stdout
+			if err := app.Explain(t.Context(), tt.target); err != nil {
+				t.Fatalf("Explain() error = %v", err)
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("Explain() output =\n%s\nwant\n%s", stdout.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApp_Run_removeAllRequiresFlag(t *testing.T) {
+	const content = "resource \"null_resource\" \"keep\" {}\nmoved {\n  from = module.foo[\"a\"]\n  to   = module.foo[\"b\"]\n}\n"
+
+	tests := []struct {
+		name        string
+		cli         CLI
+		backend     string
+		wantErr     bool
+		wantContent string
+	}{
+		{
+			name:        "no backend and no --all is an error",
+			cli:         CLI{},
+			wantErr:     true,
+			wantContent: content,
+		},
+		{
+			name: "unreadable auto-detected state is an error",
+			cli:  CLI{},
+			backend: `
+terraform {
+  backend "s3" {
+    bucket = "my-bucket"
+    key    = "terraform.tfstate"
+  }
+}
+`,
+			wantErr:     true,
+			wantContent: content,
+		},
+		{
+			name:        "--all combined with --tfstate is an error",
+			cli:         CLI{All: true, Tfstate: []string{"terraform.tfstate"}},
+			wantErr:     true,
+			wantContent: content,
+		},
+		{
+			name:        "--all removes every block",
+			cli:         CLI{All: true},
+			wantErr:     false,
+			wantContent: "resource \"null_resource\" \"keep\" {}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Keep the S3 case offline: an unreachable endpoint fails fast.
+			t.Setenv("AWS_ENDPOINT_URL_S3", "http://127.0.0.1:1")
+			t.Setenv("AWS_ACCESS_KEY_ID", "test")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+			t.Setenv("AWS_REGION", "us-east-1")
+			t.Setenv("AWS_MAX_ATTEMPTS", "1")
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "main.tf")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("write fixture: %v", err)
+			}
+			if tt.backend != "" {
+				if err := os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(tt.backend), 0644); err != nil {
+					t.Fatalf("write fixture: %v", err)
+				}
+			}
+
+			cli := tt.cli
+			cli.Dir = dir
+			err := New(&cli).Run(t.Context())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.wantContent {
+				t.Errorf("content mismatch\n got: %q\nwant: %q", string(got), tt.wantContent)
+			}
+		})
+	}
+}
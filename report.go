@@ -0,0 +1,98 @@
+package tfclean
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+type blockDecision string
+
+const (
+	decisionRemoved blockDecision = "removed"
+	decisionKept    blockDecision = "kept"
+	decisionIgnored blockDecision = "ignored"
+	decisionError   blockDecision = "error"
+)
+
+// blockResult is the decision made for a single moved, import or removed
+// block.
+type blockResult struct {
+	rng      hcl.Range
+	typ      string
+	from     string
+	to       string
+	decision blockDecision
+	// applied holds the result of the applied check for each state, in the
+	// order the states were read. It is empty for ignored blocks.
+	applied []bool
+	err     error
+}
+
+type report struct {
+	States []string      `json:"states"`
+	Blocks []reportBlock `json:"blocks"`
+}
+
+type reportBlock struct {
+	File      string        `json:"file"`
+	StartLine int           `json:"start_line"`
+	EndLine   int           `json:"end_line"`
+	Type      string        `json:"type"`
+	From      string        `json:"from,omitempty"`
+	To        string        `json:"to,omitempty"`
+	Decision  blockDecision `json:"decision"`
+	Error     string        `json:"error,omitempty"`
+	States    []reportState `json:"states"`
+}
+
+type reportState struct {
+	State   string `json:"state"`
+	Applied bool   `json:"applied"`
+}
+
+func newReport(sources []string, results []blockResult) *report {
+	r := &report{
+		States: append([]string{}, sources...),
+		Blocks: make([]reportBlock, 0, len(results)),
+	}
+	for _, result := range results {
+		block := reportBlock{
+			File:      result.rng.Filename,
+			StartLine: result.rng.Start.Line,
+			EndLine:   result.rng.End.Line,
+			Type:      result.typ,
+			From:      result.from,
+			To:        result.to,
+			Decision:  result.decision,
+			States:    make([]reportState, 0, len(result.applied)),
+		}
+		if result.err != nil {
+			block.Error = result.err.Error()
+		}
+		for i, applied := range result.applied {
+			block.States = append(block.States, reportState{State: sources[i], Applied: applied})
+		}
+		r.Blocks = append(r.Blocks, block)
+	}
+	return r
+}
+
+// writeReport writes the decisions for all blocks in the format selected by
+// --report, to --report-file or stdout.
+func (app *App) writeReport(sources []string, results []blockResult) error {
+	var w io.Writer = app.stdout
+	if app.CLI.ReportFile != "" {
+		f, err := os.Create(app.CLI.ReportFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newReport(sources, results))
+}
@@ -0,0 +1,170 @@
+package tfclean
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApp_Run_reportJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.tf")
+	content := `resource "time_static" "bbb" {}
+
+moved {
+  from = time_static.aaa
+  to   = time_static.bbb
+}
+
+# tfclean-ignore
+removed {
+  from = time_static.ccc
+}
+
+import {
+  id = "2026-05-13T13:49:53Z"
+  to = time_static.bbb
+}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	applied := filepath.Join(dir, "applied.tfstate")
+	pending := filepath.Join(dir, "pending.tfstate")
+	if err := os.WriteFile(applied, []byte(stateWithResource("bbb")), 0644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	if err := os.WriteFile(pending, []byte(stateWithResource("aaa")), 0644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+
+	var out bytes.Buffer
+	app := New(&CLI{Dir: dir, Tfstate: []string{applied, pending}, Report: "json"})
+	app.stdout = &out
+	if err := app.Run(t.Context()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var got report
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal report: %v\n%s", err, out.String())
+	}
+	want := report{
+		States: []string{applied, pending},
+		Blocks: []reportBlock{
+			{
+				File: path, StartLine: 3, EndLine: 6, Type: "moved",
+				From: "time_static.aaa", To: "time_static.bbb", Decision: decisionKept,
+				States: []reportState{{State: applied, Applied: true}, {State: pending, Applied: false}},
+			},
+			{
+				File: path, StartLine: 9, EndLine: 11, Type: "removed",
+				From: "time_static.ccc", Decision: decisionIgnored,
+				States: []reportState{},
+			},
+			{
+				File: path, StartLine: 13, EndLine: 16, Type: "import",
+				To: "time_static.bbb", Decision: decisionKept,
+				States: []reportState{{State: applied, Applied: true}, {State: pending, Applied: false}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report mismatch\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+// TestApp_Run_reportWithDryRunOrCheck covers --report with the options that
+// also print to stdout: the report has to go to --report-file so both outputs
+// stay parseable.
+func TestApp_Run_reportWithDryRunOrCheck(t *testing.T) {
+	const content = "moved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	tests := []struct {
+		name       string
+		cli        CLI
+		reportFile bool
+		wantErr    string
+		wantStdout string
+	}{
+		{
+			name:    "dry run without a report file",
+			cli:     CLI{All: true, DryRun: true, Report: "json"},
+			wantErr: "--report without --report-file cannot be combined with --dry-run",
+		},
+		{
+			name:    "check without a report file",
+			cli:     CLI{All: true, Check: true, Report: "json"},
+			wantErr: "--report without --report-file cannot be combined with --check",
+		},
+		{
+			name:       "dry run with a report file",
+			cli:        CLI{All: true, DryRun: true, Report: "json"},
+			reportFile: true,
+			wantStdout: "-moved {\n",
+		},
+		{
+			name:       "check with a report file",
+			cli:        CLI{All: true, Check: true, Report: "json"},
+			reportFile: true,
+			wantErr:    ErrCleanupNeeded.Error(),
+			wantStdout: "main.tf\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "main.tf")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("write fixture: %v", err)
+			}
+			cli := tt.cli
+			cli.Dir = dir
+			reportPath := filepath.Join(t.TempDir(), "report.json")
+			if tt.reportFile {
+				cli.ReportFile = reportPath
+			}
+			var out bytes.Buffer
+			app := New(&cli)
+			app.stdout = &out
+			err := app.Run(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if !tt.reportFile {
+				if out.Len() != 0 {
+					t.Errorf("stdout = %q, want nothing", out.String())
+				}
+				return
+			}
+			if !strings.Contains(out.String(), tt.wantStdout) || strings.Contains(out.String(), `"blocks"`) {
+				t.Errorf("stdout = %q, want it to contain %q and no report", out.String(), tt.wantStdout)
+			}
+			data, err := os.ReadFile(reportPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var r report
+			if err := json.Unmarshal(data, &r); err != nil {
+				t.Fatalf("unmarshal report: %v\n%s", err, data)
+			}
+			if len(r.Blocks) != 1 || r.Blocks[0].Decision != decisionRemoved {
+				t.Errorf("report blocks = %+v, want one removed block", r.Blocks)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content {
+				t.Errorf("main.tf was modified: %q", got)
+			}
+		})
+	}
+}
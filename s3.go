@@ -0,0 +1,253 @@
+package tfclean
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+const defaultS3WorkspaceKeyPrefix = "env:"
+
+// s3Config is the state location of an s3 backend together with the settings
+// needed to reach it.
+type s3Config struct {
+	bucket       string
+	key          string
+	region       string
+	profile      string
+	roleARN      string
+	sessionName  string
+	externalID   string
+	s3Endpoint   string
+	stsEndpoint  string
+	usePathStyle bool
+	// workspaceKeyPrefix is where the states of workspaces other than
+	// default live: <workspaceKeyPrefix>/<workspace>/<key>.
+	workspaceKeyPrefix string
+}
+
+// buildS3Config reads a backend "s3" block. Besides the current form
+// (assume_role, endpoints, use_path_style) the deprecated top-level role_arn,
+// endpoint, sts_endpoint and force_path_style are accepted.
+func (app *App) buildS3Config(backendBlock *hclsyntax.Block) (s3Config, error) {
+	cfg := s3Config{workspaceKeyPrefix: defaultS3WorkspaceKeyPrefix}
+	var err error
+	body := backendBlock.Body
+	if cfg.bucket, err = app.getStringAttribute(body, "bucket"); err != nil {
+		return s3Config{}, fmt.Errorf("s3 backend: bucket attribute is required: %w", err)
+	}
+	if cfg.key, err = app.getStringAttribute(body, "key"); err != nil {
+		return s3Config{}, fmt.Errorf("s3 backend: key attribute is required: %w", err)
+	}
+	if err := app.readOptionalStrings(body, map[string]*string{
+		"region":       &cfg.region,
+		"profile":      &cfg.profile,
+		"role_arn":     &cfg.roleARN,
+		"session_name": &cfg.sessionName,
+		"external_id":  &cfg.externalID,
+		"endpoint":     &cfg.s3Endpoint,
+		"sts_endpoint": &cfg.stsEndpoint,
+
+		"workspace_key_prefix": &cfg.workspaceKeyPrefix,
+	}); err != nil {
+		return s3Config{}, fmt.Errorf("s3 backend: %w", err)
+	}
+	for _, name := range []string{"use_path_style", "force_path_style"} {
+		if _, ok := body.Attributes[name]; !ok {
+			continue
+		}
+		if cfg.usePathStyle, err = app.getBoolAttribute(body, name); err != nil {
+			return s3Config{}, fmt.Errorf("s3 backend: %w", err)
+		}
+	}
+	for _, block := range body.Blocks {
+		var targets map[string]*string
+		switch block.Type {
+		case "assume_role":
+			targets = map[string]*string{
+				"role_arn":     &cfg.roleARN,
+				"session_name": &cfg.sessionName,
+				"external_id":  &cfg.externalID,
+			}
+		case "endpoints":
+			targets = map[string]*string{
+				"s3":  &cfg.s3Endpoint,
+				"sts": &cfg.stsEndpoint,
+			}
+		default:
+			continue
+		}
+		if err := app.readOptionalStrings(block.Body, targets); err != nil {
+			return s3Config{}, fmt.Errorf("s3 backend: %s: %w", block.Type, err)
+		}
+	}
+	return cfg, nil
+}
+
+// workspaceKey returns the key of the state of workspace ws.
+func (cfg s3Config) workspaceKey(ws string) string {
+	if ws == "default" {
+		return cfg.key
+	}
+	return path.Join(cfg.workspaceKeyPrefix, ws, cfg.key)
+}
+
+// s3WorkspaceSources lists the states of every workspace of the backend, the
+// way terraform workspace list does: the default key plus every
+// <workspace_key_prefix>/<workspace>/<key> object in the bucket.
+func (app *App) s3WorkspaceSources(ctx context.Context, cfg s3Config) ([]stateSource, error) {
+	client, err := app.s3Client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	prefix := strings.TrimSuffix(cfg.workspaceKeyPrefix, "/") + "/"
+	for _, listPrefix := range []string{cfg.key, prefix} {
+		paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(cfg.bucket),
+			Prefix: aws.String(listPrefix),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("could not list workspaces in s3://%s/%s: %w", cfg.bucket, listPrefix, err)
+			}
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				if listPrefix == cfg.key {
+					if key == cfg.key {
+						keys = append(keys, key)
+					}
+					continue
+				}
+				ws, rest, ok := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+				if ok && ws != "" && rest == cfg.key {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no workspace state found in s3://%s for key %s", cfg.bucket, cfg.key)
+	}
+	sources := make([]stateSource, 0, len(keys))
+	for _, key := range keys {
+		sources = append(sources, app.s3Source(fmt.Sprintf("s3://%s/%s", cfg.bucket, key), cfg, key))
+	}
+	return sources, nil
+}
+
+// readOptionalStrings sets each target whose attribute is present in body.
+func (app *App) readOptionalStrings(body *hclsyntax.Body, targets map[string]*string) error {
+	for name, target := range targets {
+		if _, ok := body.Attributes[name]; !ok {
+			continue
+		}
+		value, err := app.getStringAttribute(body, name)
+		if err != nil {
+			return err
+		}
+		*target = value
+	}
+	return nil
+}
+
+// s3Source reads the state object at key. name is the s3:// URL the state is
+// reported under.
+func (app *App) s3Source(name string, cfg s3Config, key string) stateSource {
+	return stateSource{
+		name: name,
+		read: func(ctx context.Context) (*tfstate.TFState, error) {
+			client, err := app.s3Client(ctx, cfg)
+			if err != nil {
+				return nil, err
+			}
+			result, err := client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(cfg.bucket),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer result.Body.Close()
+			return readState(ctx, result.Body)
+		},
+	}
+}
+
+// s3Client builds a client for the backend's bucket. Settings missing from
+// the block come from the usual AWS environment and shared config. Like
+// tfstate-lookup, the bucket's region is looked up first unless the block sets
+// a region or a custom endpoint is used, and an endpoint taken from
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL is addressed path-style, as MinIO
+// and LocalStack expect.
+func (app *App) s3Client(ctx context.Context, cfg s3Config) (*s3.Client, error) {
+	var opts []func(*config.LoadOptions) error
+	if cfg.region != "" {
+		opts = append(opts, config.WithRegion(cfg.region))
+	}
+	if cfg.profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(cfg.profile))
+	}
+	if app.httpClient != nil {
+		opts = append(opts, config.WithHTTPClient(app.httpClient))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	if cfg.roleARN != "" {
+		stsClient := sts.NewFromConfig(awsCfg, func(o *sts.Options) {
+			if cfg.stsEndpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.stsEndpoint)
+			}
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, cfg.roleARN, func(o *stscreds.AssumeRoleOptions) {
+			if cfg.sessionName != "" {
+				o.RoleSessionName = cfg.sessionName
+			}
+			if cfg.externalID != "" {
+				o.ExternalID = aws.String(cfg.externalID)
+			}
+		}))
+	}
+
+	envEndpoint := os.Getenv("AWS_ENDPOINT_URL_S3") != "" || os.Getenv("AWS_ENDPOINT_URL") != ""
+	s3Opts := func(o *s3.Options) {
+		if cfg.s3Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.s3Endpoint)
+		}
+		o.UsePathStyle = cfg.usePathStyle || (cfg.s3Endpoint == "" && envEndpoint)
+	}
+	// A region from the environment may not be the bucket's, so only a
+	// region set in the block skips the lookup.
+	if cfg.region == "" && cfg.s3Endpoint == "" && !envEndpoint {
+		lookupCfg := awsCfg.Copy()
+		if lookupCfg.Region == "" {
+			lookupCfg.Region = "us-east-1"
+		}
+		region, err := manager.GetBucketRegion(ctx, s3.NewFromConfig(lookupCfg, s3Opts), cfg.bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bucket region: %w", err)
+		}
+		awsCfg.Region = region
+	}
+	if awsCfg.Region == "" {
+		// S3-compatible stores generally ignore the region, but requests must
+		// still be signed with one.
+		awsCfg.Region = "us-east-1"
+	}
+	return s3.NewFromConfig(awsCfg, s3Opts), nil
+}
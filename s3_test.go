@@ -0,0 +1,516 @@
+package tfclean
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a local stand-in for S3 and STS. It serves objects with
+// path-style GET requests, answers AssumeRole with fixed credentials, and
+// records the credential scope each S3 request was signed with.
+type fakeS3 struct {
+	*httptest.Server
+	objects map[string]string
+
+	mu     sync.Mutex
+	scopes []string
+}
+
+func newFakeS3(t *testing.T, objects map[string]string) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{objects: objects}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "AssumeRole" {
+				http.Error(w, "unsupported", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/xml")
+			_, _ = w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMED</AccessKeyId>
+      <SecretAccessKey>assumed-secret</SecretAccessKey>
+      <SessionToken>assumed-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::111111111111:assumed-role/state-reader/` + r.Form.Get("RoleSessionName") + `</Arn>
+      <AssumedRoleId>AROAEXAMPLE:` + r.Form.Get("RoleSessionName") + `</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`))
+			return
+		}
+		if r.URL.Query().Has("list-type") {
+			f.list(w, strings.TrimPrefix(r.URL.Path, "/"), r.URL.Query().Get("prefix"))
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if i := strings.Index(auth, "Credential="); i >= 0 {
+			scope, _, _ := strings.Cut(auth[i+len("Credential="):], ",")
+			f.mu.Lock()
+			f.scopes = append(f.scopes, scope)
+			f.mu.Unlock()
+		}
+		body, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// list answers ListObjectsV2 with every object of bucket under prefix, in a
+// single page.
+func (f *fakeS3) list(w http.ResponseWriter, bucket, prefix string) {
+	var keys []string
+	for key := range f.objects {
+		if name, ok := strings.CutPrefix(key, bucket+"/"); ok && strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	fmt.Fprintf(&buf, "<Name>%s</Name><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>", bucket, len(keys))
+	for _, key := range keys {
+		buf.WriteString("<Contents><Key>")
+		_ = xml.EscapeText(&buf, []byte(key))
+		buf.WriteString("</Key></Contents>")
+	}
+	buf.WriteString("</ListBucketResult>")
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (f *fakeS3) lastScope() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.scopes) == 0 {
+		return ""
+	}
+	return f.scopes[len(f.scopes)-1]
+}
+
+// isolateAWSEnv points the AWS SDK at fixed default credentials and empty
+// shared config so the tests do not depend on the machine they run on.
+func isolateAWSEnv(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	for name, value := range map[string]string{
+		"AWS_ACCESS_KEY_ID":           "AKIADEFAULT",
+		"AWS_SECRET_ACCESS_KEY":       "default-secret",
+		"AWS_SESSION_TOKEN":           "",
+		"AWS_PROFILE":                 "",
+		"AWS_DEFAULT_PROFILE":         "",
+		"AWS_REGION":                  "",
+		"AWS_DEFAULT_REGION":          "",
+		"AWS_ENDPOINT_URL":            "",
+		"AWS_ENDPOINT_URL_S3":         "",
+		"AWS_EC2_METADATA_DISABLED":   "true",
+		"AWS_CA_BUNDLE":               "",
+		"AWS_CONFIG_FILE":             filepath.Join(home, "config"),
+		"AWS_SHARED_CREDENTIALS_FILE": filepath.Join(home, "credentials"),
+		"TF_WORKSPACE":                "",
+	} {
+		t.Setenv(name, value)
+	}
+	return home
+}
+
+func TestApp_Run_s3BackendSettings(t *testing.T) {
+	const main = "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	const cleaned = "resource \"time_static\" \"bbb\" {}\n\n"
+
+	tests := []struct {
+		name      string
+		backend   string
+		want      string
+		wantScope string
+		wantErr   string
+	}{
+		{
+			name: "endpoint, path style and region",
+			backend: `bucket         = "states"
+key            = "app/terraform.tfstate"
+region         = "eu-west-1"
+use_path_style = true
+endpoints {
+  s3 = "URL"
+}`,
+			want:      cleaned,
+			wantScope: "AKIADEFAULT/20",
+		},
+		{
+			name: "region is used to sign requests",
+			backend: `bucket         = "states"
+key            = "app/terraform.tfstate"
+region         = "ap-northeast-1"
+use_path_style = true
+endpoints {
+  s3 = "URL"
+}`,
+			want:      cleaned,
+			wantScope: "/ap-northeast-1/s3/",
+		},
+		{
+			name: "profile from shared config",
+			backend: `bucket         = "states"
+key            = "app/terraform.tfstate"
+region         = "eu-west-1"
+profile        = "state-reader"
+use_path_style = true
+endpoints {
+  s3 = "URL"
+}`,
+			want:      cleaned,
+			wantScope: "AKIAPROFILE/",
+		},
+		{
+			name: "assume_role in another account",
+			backend: `bucket         = "states"
+key            = "app/terraform.tfstate"
+region         = "eu-west-1"
+use_path_style = true
+assume_role {
+  role_arn     = "arn:aws:iam::111111111111:role/state-reader"
+  session_name = "tfclean"
+}
+endpoints {
+  s3  = "URL"
+  sts = "URL"
+}`,
+			want:      cleaned,
+			wantScope: "ASIAASSUMED/",
+		},
+		{
+			name: "deprecated top-level settings",
+			backend: `bucket           = "states"
+key              = "app/terraform.tfstate"
+region           = "eu-west-1"
+role_arn         = "arn:aws:iam::111111111111:role/state-reader"
+endpoint         = "URL"
+sts_endpoint     = "URL"
+force_path_style = true`,
+			want:      cleaned,
+			wantScope: "ASIAASSUMED/",
+		},
+		{
+			name: "missing object",
+			backend: `bucket         = "states"
+key            = "missing.tfstate"
+region         = "eu-west-1"
+use_path_style = true
+endpoints {
+  s3 = "URL"
+}`,
+			wantErr: "could not read state from auto-detected location s3://states/missing.tfstate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateAWSEnv(t)
+			if err := os.WriteFile(filepath.Join(home, "config"), []byte("[profile state-reader]\nregion = eu-west-1\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(home, "credentials"), []byte("[state-reader]\naws_access_key_id = AKIAPROFILE\naws_secret_access_key = profile-secret\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			server := newFakeS3(t, map[string]string{"states/app/terraform.tfstate": stateWithResource("bbb")})
+
+			dir := t.TempDir()
+			backend := "terraform {\n  backend \"s3\" {\n" + strings.ReplaceAll(tt.backend, "URL", server.URL) + "\n  }\n}\n"
+			if err := os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(backend), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(main), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := New(&CLI{Dir: dir}).Run(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if scope := server.lastScope(); !strings.Contains(scope, tt.wantScope) {
+				t.Errorf("S3 request signed with %q, want it to contain %q", scope, tt.wantScope)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("main.tf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// dialingClient returns an HTTP client that connects to server whatever host
+// a request names, so tests can use hostnames, including virtual-hosted
+// bucket names, that do not resolve.
+func dialingClient(server *httptest.Server) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+}
+
+// TestApp_Run_s3EnvironmentEndpoint covers a backend block without endpoint
+// settings read through AWS_ENDPOINT_URL_S3, as with MinIO or LocalStack. The
+// endpoint has a hostname, so only path-style requests reach the bucket.
+func TestApp_Run_s3EnvironmentEndpoint(t *testing.T) {
+	isolateAWSEnv(t)
+	server := newFakeS3(t, map[string]string{"states/app/terraform.tfstate": stateWithResource("bbb")})
+	t.Setenv("AWS_ENDPOINT_URL_S3", "http://minio.test:9000")
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"backend.tf": "terraform {\n  backend \"s3\" {\n    bucket = \"states\"\n    key    = \"app/terraform.tfstate\"\n  }\n}\n",
+		"main.tf":    "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n",
+	})
+	app := New(&CLI{Dir: dir})
+	app.httpClient = dialingClient(server.Server)
+	if err := app.Run(t.Context()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "resource \"time_static\" \"bbb\" {}\n\n"; string(got) != want {
+		t.Errorf("main.tf = %q, want %q", got, want)
+	}
+}
+
+// TestApp_Run_s3BucketRegion covers the bucket region lookup. A region from
+// the environment may differ from the bucket's, so it does not skip the
+// lookup; a region set in the block does.
+func TestApp_Run_s3BucketRegion(t *testing.T) {
+	tests := []struct {
+		name       string
+		region     string
+		wantLookup bool
+		wantScope  string
+	}{
+		{name: "region from the environment", wantLookup: true, wantScope: "/ap-northeast-1/s3/"},
+		{name: "region in the block", region: "eu-west-1", wantScope: "/eu-west-1/s3/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateAWSEnv(t)
+			t.Setenv("AWS_REGION", "eu-west-1")
+			var mu sync.Mutex
+			var lookups int
+			var scope string
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.Method == http.MethodHead {
+					lookups++
+					w.Header().Set("X-Amz-Bucket-Region", "ap-northeast-1")
+					return
+				}
+				auth := r.Header.Get("Authorization")
+				if i := strings.Index(auth, "Credential="); i >= 0 {
+					scope, _, _ = strings.Cut(auth[i+len("Credential="):], ",")
+				}
+				if !strings.HasPrefix(r.Host, "states.") || r.URL.Path != "/app/terraform.tfstate" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_, _ = w.Write([]byte(stateWithResource("bbb")))
+			}))
+			t.Cleanup(server.Close)
+
+			backend := "terraform {\n  backend \"s3\" {\n    bucket = \"states\"\n    key    = \"app/terraform.tfstate\"\n"
+			if tt.region != "" {
+				backend += "    region = \"" + tt.region + "\"\n"
+			}
+			backend += "  }\n}\n"
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"backend.tf": backend, "main.tf": "resource \"time_static\" \"bbb\" {}\n"})
+			app := New(&CLI{Dir: dir})
+			app.httpClient = dialingClient(server)
+			if err := app.Run(t.Context()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if (lookups > 0) != tt.wantLookup {
+				t.Errorf("bucket region lookups = %d, want lookup %v", lookups, tt.wantLookup)
+			}
+			if !strings.Contains(scope, tt.wantScope) {
+				t.Errorf("S3 request signed with %q, want it to contain %q", scope, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestApp_Run_s3AllWorkspaces(t *testing.T) {
+	const main = "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	const cleaned = "resource \"time_static\" \"bbb\" {}\n\n"
+	const backend = `terraform {
+  backend "s3" {
+    bucket               = "states"
+    key                  = "app/terraform.tfstate"
+    region               = "eu-west-1"
+    use_path_style       = true
+    WORKSPACE_KEY_PREFIX
+    endpoints {
+      s3 = "URL"
+    }
+  }
+}
+`
+
+	tests := []struct {
+		name        string
+		prefix      string
+		objects     map[string]string
+		workspace   string
+		allWS       bool
+		wantSources []string
+		want        string
+		wantErr     string
+	}{
+		{
+			name: "staging still on the old address",
+			objects: map[string]string{
+				"states/app/terraform.tfstate":               stateWithResource("bbb"),
+				"states/env:/staging/app/terraform.tfstate":  stateWithResource("aaa"),
+				"states/env:/prod/app/terraform.tfstate":     stateWithResource("bbb"),
+				"states/env:/prod/other/terraform.tfstate":   stateWithResource("aaa"),
+				"states/app/terraform.tfstate.backup":        stateWithResource("aaa"),
+				"states/env:/staging/app/terraform.tfstate2": stateWithResource("aaa"),
+			},
+			allWS: true,
+			wantSources: []string{
+				"s3://states/app/terraform.tfstate",
+				"s3://states/env:/prod/app/terraform.tfstate",
+				"s3://states/env:/staging/app/terraform.tfstate",
+			},
+			want: main,
+		},
+		{
+			name:   "applied everywhere with a custom prefix and no default state",
+			prefix: `workspace_key_prefix = "workspaces"`,
+			objects: map[string]string{
+				"states/workspaces/staging/app/terraform.tfstate": stateWithResource("bbb"),
+				"states/workspaces/prod/app/terraform.tfstate":    stateWithResource("bbb"),
+				"states/env:/old/app/terraform.tfstate":           stateWithResource("aaa"),
+			},
+			allWS: true,
+			wantSources: []string{
+				"s3://states/workspaces/prod/app/terraform.tfstate",
+				"s3://states/workspaces/staging/app/terraform.tfstate",
+			},
+			want: cleaned,
+		},
+		{
+			name: "without the option only the current workspace is read",
+			objects: map[string]string{
+				"states/app/terraform.tfstate":              stateWithResource("aaa"),
+				"states/env:/staging/app/terraform.tfstate": stateWithResource("bbb"),
+			},
+			workspace:   "staging",
+			wantSources: []string{"s3://states/env:/staging/app/terraform.tfstate"},
+			want:        cleaned,
+		},
+		{
+			name:    "no workspace state at all",
+			objects: map[string]string{},
+			allWS:   true,
+			wantErr: "no workspace state found in s3://states",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateAWSEnv(t)
+			t.Setenv("TF_WORKSPACE", tt.workspace)
+			server := newFakeS3(t, tt.objects)
+
+			dir := t.TempDir()
+			content := strings.NewReplacer("URL", server.URL, "WORKSPACE_KEY_PREFIX", tt.prefix).Replace(backend)
+			if err := os.WriteFile(filepath.Join(dir, "backend.tf"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(main), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			app := New(&CLI{Dir: dir, AllWorkspaces: tt.allWS})
+			err := app.Run(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			sources, err := app.detectBackendFromConfig(t.Context())
+			if err != nil {
+				t.Fatalf("detectBackendFromConfig() error = %v", err)
+			}
+			if got, want := sourceNames(sources), strings.Join(tt.wantSources, ", "); got != want {
+				t.Errorf("detectBackendFromConfig() = %v, want %v", got, want)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("main.tf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_allWorkspacesConflicts(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "backend.tf"), []byte("terraform {\n  backend \"gcs\" {\n    bucket = \"states\"\n  }\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cli     CLI
+		wantErr string
+	}{
+		{cli: CLI{AllWorkspaces: true, All: true}, wantErr: "--all cannot be combined with --all-workspaces"},
+		{cli: CLI{AllWorkspaces: true, Tfstate: []string{"terraform.tfstate"}}, wantErr: "cannot be combined with --tfstate"},
+		{cli: CLI{AllWorkspaces: true}, wantErr: "--all-workspaces is not supported for the gcs backend"},
+	}
+	for _, tt := range tests {
+		cli := tt.cli
+		cli.Dir = dir
+		err := New(&cli).Run(t.Context())
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Run(%+v) error = %v, want it to contain %q", tt.cli, err, tt.wantErr)
+		}
+	}
+}
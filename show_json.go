@@ -0,0 +1,159 @@
+package tfclean
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+)
+
+// showJSON is the output of terraform show -json for a state. Unlike a raw
+// state, resources are grouped by module and each instance is listed on its
+// own with its index.
+type showJSON struct {
+	FormatVersion string `json:"format_version"`
+	Values        *struct {
+		Outputs    map[string]json.RawMessage `json:"outputs"`
+		RootModule showJSONModule             `json:"root_module"`
+	} `json:"values"`
+
+	// Set in the JSON of a plan, which is not a state.
+	PlannedValues   json.RawMessage `json:"planned_values"`
+	ResourceChanges json.RawMessage `json:"resource_changes"`
+}
+
+type showJSONModule struct {
+	Address      string             `json:"address"`
+	Resources    []showJSONResource `json:"resources"`
+	ChildModules []showJSONModule   `json:"child_modules"`
+}
+
+type showJSONResource struct {
+	Mode   string          `json:"mode"`
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Index  json.RawMessage `json:"index"`
+	Values json.RawMessage `json:"values"`
+}
+
+// readState reads a state in either the raw format of terraform state pull or
+// the format of terraform show -json.
+func readState(ctx context.Context, r io.Reader) (*tfstate.TFState, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = normalizeState(data); err != nil {
+		return nil, err
+	}
+	return tfstate.Read(ctx, bytes.NewReader(data))
+}
+
+// isShowJSON reports whether data is the JSON output of a terraform command
+// rather than a raw state. normalizeState decides whether it is a state.
+func isShowJSON(data []byte) bool {
+	var show showJSON
+	return json.Unmarshal(data, &show) == nil && show.FormatVersion != ""
+}
+
+// showJSONKeys are the top-level keys of terraform show -json for a state.
+var showJSONKeys = map[string]bool{"format_version": true, "terraform_version": true, "values": true}
+
+// normalizeState returns data unchanged when it is a raw state, and converts
+// it into one when it is terraform show -json output. Anything that is not a
+// JSON object is returned as is for tfstate.Read to report. The JSON output
+// of other commands, such as validate -json or providers schema -json, also
+// has a format_version; it is rejected rather than read as an empty state.
+func normalizeState(data []byte) ([]byte, error) {
+	var show showJSON
+	if err := json.Unmarshal(data, &show); err != nil || show.FormatVersion == "" {
+		return data, nil
+	}
+	if show.PlannedValues != nil || show.ResourceChanges != nil {
+		return nil, fmt.Errorf("this is the JSON of a plan, not of a state")
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for key := range keys {
+		if !showJSONKeys[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("this is not the JSON of a state: unexpected keys %s", strings.Join(unknown, ", "))
+	}
+	if keys["values"] == nil && keys["terraform_version"] == nil {
+		return nil, fmt.Errorf("this is not the JSON of a state: neither values nor terraform_version is set")
+	}
+	return show.toState()
+}
+
+// toState builds the raw version 4 state with the same resources, instances
+// and outputs. terraform show -json leaves values out entirely when the state
+// is empty.
+func (show *showJSON) toState() ([]byte, error) {
+	type instance struct {
+		IndexKey   json.RawMessage `json:"index_key,omitempty"`
+		Attributes json.RawMessage `json:"attributes"`
+	}
+	type resource struct {
+		Module    string     `json:"module,omitempty"`
+		Mode      string     `json:"mode"`
+		Type      string     `json:"type"`
+		Name      string     `json:"name"`
+		Each      string     `json:"each,omitempty"`
+		Instances []instance `json:"instances"`
+	}
+	state := struct {
+		Version   int                        `json:"version"`
+		Outputs   map[string]json.RawMessage `json:"outputs"`
+		Resources []*resource                `json:"resources"`
+	}{Version: 4, Outputs: map[string]json.RawMessage{}, Resources: []*resource{}}
+	if show.Values == nil {
+		return json.Marshal(state)
+	}
+
+	for name, output := range show.Values.Outputs {
+		state.Outputs[name] = output
+	}
+	byAddress := map[string]*resource{}
+	var walk func(module showJSONModule)
+	walk = func(module showJSONModule) {
+		for _, r := range module.Resources {
+			key := module.Address + "\x00" + r.Mode + "." + r.Type + "." + r.Name
+			res, ok := byAddress[key]
+			if !ok {
+				res = &resource{Module: module.Address, Mode: r.Mode, Type: r.Type, Name: r.Name}
+				byAddress[key] = res
+				state.Resources = append(state.Resources, res)
+			}
+			attributes := r.Values
+			if attributes == nil {
+				attributes = json.RawMessage("{}")
+			}
+			ins := instance{Attributes: attributes}
+			if len(r.Index) > 0 && string(r.Index) != "null" {
+				ins.IndexKey = r.Index
+				res.Each = "list"
+				if r.Index[0] == '"' {
+					res.Each = "map"
+				}
+			}
+			res.Instances = append(res.Instances, ins)
+		}
+		for _, child := range module.ChildModules {
+			walk(child)
+		}
+	}
+	walk(show.Values.RootModule)
+	return json.Marshal(state)
+}
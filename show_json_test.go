@@ -0,0 +1,232 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const showJSONState = `{
+  "format_version": "1.0",
+  "terraform_version": "1.9.0",
+  "values": {
+    "outputs": {
+      "ip": {"sensitive": false, "value": "10.0.0.1", "type": "string"}
+    },
+    "root_module": {
+      "resources": [
+        {
+          "address": "time_static.bbb",
+          "mode": "managed",
+          "type": "time_static",
+          "name": "bbb",
+          "provider_name": "registry.terraform.io/hashicorp/time",
+          "schema_version": 0,
+          "values": {"id": "2026-05-13T13:49:53Z"},
+          "sensitive_values": {}
+        },
+        {
+          "address": "aws_instance.web[0]",
+          "mode": "managed",
+          "type": "aws_instance",
+          "name": "web",
+          "index": 0,
+          "values": {"id": "i-0"}
+        },
+        {
+          "address": "aws_instance.web[1]",
+          "mode": "managed",
+          "type": "aws_instance",
+          "name": "web",
+          "index": 1,
+          "values": {"id": "i-1"}
+        },
+        {
+          "address": "data.aws_ami.this",
+          "mode": "data",
+          "type": "aws_ami",
+          "name": "this",
+          "values": {"id": "ami-1"}
+        }
+      ],
+      "child_modules": [
+        {
+          "address": "module.platform[\"a\"]",
+          "resources": [
+            {
+              "address": "module.platform[\"a\"].aws_s3_bucket.logs[\"x\"]",
+              "mode": "managed",
+              "type": "aws_s3_bucket",
+              "name": "logs",
+              "index": "x",
+              "values": {"id": "logs-x"}
+            }
+          ],
+          "child_modules": [
+            {
+              "address": "module.platform[\"a\"].module.network",
+              "resources": [
+                {
+                  "address": "module.platform[\"a\"].module.network.aws_vpc.this",
+                  "mode": "managed",
+                  "type": "aws_vpc",
+                  "name": "this",
+                  "values": {"id": "vpc-1"}
+                }
+              ]
+            }
+          ]
+        }
+      ]
+    }
+  }
+}`
+
+func TestReadState_showJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "resources, instances, modules and outputs",
+			data: showJSONState,
+			want: []string{
+				"aws_instance.web[0]",
+				"aws_instance.web[1]",
+				"data.aws_ami.this",
+				`module.platform["a"].aws_s3_bucket.logs["x"]`,
+				`module.platform["a"].module.network.aws_vpc.this`,
+				"output.ip",
+				"time_static.bbb",
+			},
+		},
+		{
+			name: "empty state",
+			data: `{"format_version": "1.0", "terraform_version": "1.9.0"}`,
+			want: []string{},
+		},
+		{
+			name: "raw state",
+			data: stateWithResource("bbb"),
+			want: []string{"time_static.bbb"},
+		},
+		{
+			name:    "plan",
+			data:    `{"format_version": "1.2", "planned_values": {}, "resource_changes": []}`,
+			wantErr: "this is the JSON of a plan, not of a state",
+		},
+		{
+			name:    "validate -json",
+			data:    `{"format_version": "1.0", "valid": true, "error_count": 0, "warning_count": 0, "diagnostics": []}`,
+			wantErr: "unexpected keys diagnostics, error_count, valid, warning_count",
+		},
+		{
+			name:    "providers schema -json",
+			data:    `{"format_version": "1.0", "provider_schemas": {}}`,
+			wantErr: "unexpected keys provider_schemas",
+		},
+		{
+			name:    "format_version only",
+			data:    `{"format_version": "1.0"}`,
+			wantErr: "neither values nor terraform_version is set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := readState(t.Context(), strings.NewReader(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readState() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readState() error = %v", err)
+			}
+			got, err := state.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_showJSON(t *testing.T) {
+	const main = `resource "time_static" "bbb" {}
+
+moved {
+  from = time_static.aaa
+  to   = time_static.bbb
+}
+
+removed {
+  from = module.platform.module.network
+}
+
+removed {
+  from = aws_instance.web[2]
+}
+
+import {
+  to = aws_instance.web[1]
+  id = "i-1"
+}
+`
+	const want = `resource "time_static" "bbb" {}
+
+removed {
+  from = module.platform.module.network
+}
+
+`
+	tests := []struct {
+		name  string
+		cli   func(path string) CLI
+		stdin string
+	}{
+		{
+			name: "file",
+			cli:  func(path string) CLI { return CLI{Tfstate: []string{path}} },
+		},
+		{
+			name: "file URL",
+			cli:  func(path string) CLI { return CLI{Tfstate: []string{"file://" + path}} },
+		},
+		{
+			name:  "stdin",
+			cli:   func(string) CLI { return CLI{Tfstate: []string{"-"}} },
+			stdin: showJSONState,
+		},
+		{
+			name: "state command",
+			cli:  func(path string) CLI { return CLI{StateCommand: []string{"cat " + path}} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.tf": main, "state.json": showJSONState})
+			cli := tt.cli(filepath.Join(dir, "state.json"))
+			cli.Dir = dir
+			app := New(&cli)
+			app.stdin = strings.NewReader(tt.stdin)
+			if err := app.Run(t.Context()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("main.tf = %q, want %q", got, want)
+			}
+		})
+	}
+}
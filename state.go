@@ -0,0 +1,98 @@
+package tfclean
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+)
+
+// stateSource is a state to check blocks against, with the name it is
+// reported under.
+type stateSource struct {
+	name string
+	read func(ctx context.Context) (*tfstate.TFState, error)
+}
+
+// urlSource reads a state from a URL or local path understood by
+// tfstate.ReadURL. A local file, given as a path or a file:// URL, may also
+// hold terraform show -json output; the other URLs must hold a raw state.
+func urlSource(url string) stateSource {
+	return stateSource{
+		name: url,
+		read: func(ctx context.Context) (*tfstate.TFState, error) {
+			if path, ok := localStatePath(url); ok {
+				if data, err := os.ReadFile(path); err == nil && isShowJSON(data) {
+					return readState(ctx, bytes.NewReader(data))
+				}
+			}
+			return tfstate.ReadURL(ctx, url)
+		},
+	}
+}
+
+// localStatePath returns the file a --tfstate value names when it is a local
+// path or a file:// URL.
+func localStatePath(value string) (string, bool) {
+	if !strings.Contains(value, "://") {
+		return value, true
+	}
+	u, err := neturl.Parse(value)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// backendSource reads the state of workspace ws from a remote backend
+// described by its type and configuration, for settings a URL cannot carry.
+func backendSource(name, backendType string, config map[string]any, ws string) stateSource {
+	return stateSource{
+		name: name,
+		read: func(ctx context.Context) (*tfstate.TFState, error) {
+			doc, err := json.Marshal(map[string]any{
+				"backend": map[string]any{"type": backendType, "config": config},
+			})
+			if err != nil {
+				return nil, err
+			}
+			return tfstate.ReadWithWorkspace(ctx, bytes.NewReader(doc), ws)
+		},
+	}
+}
+
+// readerSource reads a state from r, such as the standard input.
+func readerSource(name string, r io.Reader) stateSource {
+	return stateSource{
+		name: name,
+		read: func(ctx context.Context) (*tfstate.TFState, error) {
+			return readState(ctx, r)
+		},
+	}
+}
+
+// commandSource runs command with the shell and reads the state it prints,
+// as with terraform state pull. The command's stderr is passed through; a
+// non-zero exit status is an error.
+func commandSource(command string) stateSource {
+	return stateSource{
+		name: command,
+		read: func(ctx context.Context) (*tfstate.TFState, error) {
+			var stdout bytes.Buffer
+			cmd := exec.CommandContext(ctx, "sh", "-c", command)
+			cmd.Stdout = &stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				return nil, fmt.Errorf("state command failed: %w", err)
+			}
+			return readState(ctx, &stdout)
+		},
+	}
+}
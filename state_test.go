@@ -0,0 +1,155 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestApp_Run_stdinAndStateCommand(t *testing.T) {
+	const main = "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	const cleaned = "resource \"time_static\" \"bbb\" {}\n\n"
+
+	tests := []struct {
+		name     string
+		stdin    string
+		tfstate  []string
+		commands func(dir string) []string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:    "state from stdin",
+			stdin:   stateWithResource("bbb"),
+			tfstate: []string{"-"},
+			want:    cleaned,
+		},
+		{
+			name:    "stdin pending",
+			stdin:   stateWithResource("aaa"),
+			tfstate: []string{"-"},
+			want:    main,
+		},
+		{
+			name: "state commands applied",
+			commands: func(dir string) []string {
+				return []string{"cat " + filepath.Join(dir, "applied.tfstate"), "cd " + dir + " && cat applied.tfstate"}
+			},
+			want: cleaned,
+		},
+		{
+			name: "state command pending",
+			commands: func(dir string) []string {
+				return []string{"cat " + filepath.Join(dir, "applied.tfstate"), "cat " + filepath.Join(dir, "pending.tfstate")}
+			},
+			want: main,
+		},
+		{
+			name:    "stdin and a state command",
+			stdin:   stateWithResource("bbb"),
+			tfstate: []string{"-"},
+			commands: func(dir string) []string {
+				return []string{"cat " + filepath.Join(dir, "pending.tfstate")}
+			},
+			want: main,
+		},
+		{
+			name: "state command exits with an error",
+			commands: func(string) []string {
+				return []string{"echo 'no credentials' >&2; exit 3"}
+			},
+			wantErr: "could not read state from echo 'no credentials' >&2; exit 3: state command failed: exit status 3",
+		},
+		{
+			name: "state command prints something other than JSON",
+			commands: func(string) []string {
+				return []string{"echo not a state"}
+			},
+			wantErr: "could not read state from echo not a state",
+		},
+		{
+			name:    "stdin that is not JSON",
+			stdin:   "not a state",
+			tfstate: []string{"-"},
+			wantErr: "could not read state from stdin",
+		},
+		{
+			name:    "stdin twice",
+			stdin:   stateWithResource("bbb"),
+			tfstate: []string{"-", "-"},
+			wantErr: "--tfstate - can only be given once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"main.tf":         main,
+				"applied.tfstate": stateWithResource("bbb"),
+				"pending.tfstate": stateWithResource("aaa"),
+			})
+			cli := CLI{Dir: dir, Tfstate: tt.tfstate}
+			if tt.commands != nil {
+				cli.StateCommand = tt.commands(dir)
+			}
+			app := New(&cli)
+			app.stdin = strings.NewReader(tt.stdin)
+			err := app.Run(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("main.tf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_stateCommandConflicts(t *testing.T) {
+	tests := []struct {
+		cli     CLI
+		wantErr string
+	}{
+		{cli: CLI{StateCommand: []string{"true"}, All: true}, wantErr: "--all cannot be combined with --state-command"},
+		{cli: CLI{StateCommand: []string{"true"}, AllWorkspaces: true}, wantErr: "--all-workspaces only applies to the auto-detected backend"},
+	}
+	for _, tt := range tests {
+		cli := tt.cli
+		cli.Dir = t.TempDir()
+		err := New(&cli).Run(t.Context())
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Run(%+v) error = %v, want it to contain %q", tt.cli, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCLI_parseStdinAndStateCommand(t *testing.T) {
+	var cli CLI
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse([]string{"--tfstate", "-", "--state-command", "terraform -chdir=x state pull", "--state-command", "terraform -chdir=y state pull | jq '{version, resources}'", "dir"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if want := []string{"-"}; !reflect.DeepEqual(cli.Tfstate, want) {
+		t.Errorf("Tfstate = %q, want %q", cli.Tfstate, want)
+	}
+	if want := []string{"terraform -chdir=x state pull", "terraform -chdir=y state pull | jq '{version, resources}'"}; !reflect.DeepEqual(cli.StateCommand, want) {
+		t.Errorf("StateCommand = %q, want %q", cli.StateCommand, want)
+	}
+}
@@ -0,0 +1,87 @@
+package tfclean
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+func TestApp_applyAllDeletions_unresolvableAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "missing to attribute",
+			data: `
+resource "null_resource" "aaa" {}
+moved {
+  from = module.foo
+}
+`,
+			wantErr: "memory.tf:3: moved block has no to attribute",
+		},
+		{
+			name: "missing from attribute",
+			data: `
+removed {
+  lifecycle {
+    destroy = false
+  }
+}
+`,
+			wantErr: "memory.tf:2: removed block has no from attribute",
+		},
+		{
+			name: "incomplete resource address",
+			data: `
+moved {
+  from = module.foo.hoge
+  to   = module.foo.piyo
+}
+`,
+			wantErr: "memory.tf:3: cannot resolve from of moved block: resource address must have a type and a name",
+		},
+		{
+			name: "expression that is not a reference",
+			data: `
+import {
+  id = "resource_id"
+  to = "${upper("module")}.foo"
+}
+`,
+			wantErr: "memory.tf:4: cannot resolve to of import block: expected a reference",
+		},
+	}
+
+	// Addresses only matter when a state is consulted; with --all every block
+	// is removed regardless.
+	state, err := tfstate.Read(t.Context(), strings.NewReader(stateWithResource("aaa")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := []*tfstate.TFState{state}
+
+	for _, tt := range tests {
+		t.Run(tt.name+": kept by default", func(t *testing.T) {
+			app := &App{hclParser: hclparse.NewParser(), CLI: &CLI{}}
+			got, err := app.applyAllDeletions([]byte(tt.data), states)
+			if err != nil {
+				t.Fatalf("applyAllDeletions() error = %v", err)
+			}
+			if string(got) != tt.data {
+				t.Errorf("applyAllDeletions() got = %q, want %q", got, tt.data)
+			}
+		})
+		t.Run(tt.name+": error with --strict", func(t *testing.T) {
+			app := &App{hclParser: hclparse.NewParser(), CLI: &CLI{Strict: true}}
+			_, err := app.applyAllDeletions([]byte(tt.data), states)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("applyAllDeletions() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package tfclean
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fujiwara/tfstate-lookup/tfstate"
+	tfe "github.com/hashicorp/go-tfe"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const defaultTFEHostname = "app.terraform.io"
+
+// tfeConfig is the workspace selection of a cloud block or a remote backend.
+// Exactly one of name, prefix, tags and tagBindings selects the workspaces.
+type tfeConfig struct {
+	hostname     string
+	organization string
+	token        string
+	name         string
+	prefix       string
+	tags         []string
+	tagBindings  map[string]string
+}
+
+// buildCloudConfig reads a terraform { cloud { ... } } block. As in
+// Terraform, TF_CLOUD_ORGANIZATION and TF_CLOUD_HOSTNAME fill in missing
+// settings, and TF_WORKSPACE picks a single workspace.
+func (app *App) buildCloudConfig(cloudBlock *hclsyntax.Block) (tfeConfig, error) {
+	cfg, err := app.buildTFEConnection(cloudBlock, "cloud block")
+	if err != nil {
+		return tfeConfig{}, err
+	}
+	if cfg.organization == "" {
+		cfg.organization = os.Getenv("TF_CLOUD_ORGANIZATION")
+	}
+	if cfg.hostname == defaultTFEHostname {
+		if hostname := os.Getenv("TF_CLOUD_HOSTNAME"); hostname != "" {
+			cfg.hostname = hostname
+		}
+	}
+	if cfg.organization == "" {
+		return tfeConfig{}, fmt.Errorf("cloud block: organization is required")
+	}
+
+	for _, block := range cloudBlock.Body.Blocks {
+		if block.Type != "workspaces" {
+			continue
+		}
+		if _, ok := block.Body.Attributes["name"]; ok {
+			if cfg.name, err = app.getStringAttribute(block.Body, "name"); err != nil {
+				return tfeConfig{}, fmt.Errorf("cloud block: %w", err)
+			}
+		}
+		if attr, ok := block.Body.Attributes["tags"]; ok {
+			val, diags := attr.Expr.Value(nil)
+			if diags.HasErrors() {
+				return tfeConfig{}, fmt.Errorf("cloud block: error evaluating attribute tags: %v", diags)
+			}
+			if cfg.tags, cfg.tagBindings, err = parseTags(val); err != nil {
+				return tfeConfig{}, fmt.Errorf("cloud block: %w", err)
+			}
+		}
+	}
+	if ws := os.Getenv("TF_WORKSPACE"); ws != "" {
+		cfg.name, cfg.tags, cfg.tagBindings = ws, nil, nil
+	}
+	if cfg.name == "" && len(cfg.tags) == 0 && len(cfg.tagBindings) == 0 {
+		return tfeConfig{}, fmt.Errorf("cloud block: workspaces requires either name or tags, or TF_WORKSPACE to be set")
+	}
+	return cfg, nil
+}
+
+// buildRemoteConfig reads a backend "remote" block. TF_WORKSPACE picks a
+// single workspace out of a prefix.
+func (app *App) buildRemoteConfig(backendBlock *hclsyntax.Block) (tfeConfig, error) {
+	cfg, err := app.buildTFEConnection(backendBlock, "remote backend")
+	if err != nil {
+		return tfeConfig{}, err
+	}
+	if cfg.organization == "" {
+		return tfeConfig{}, fmt.Errorf("remote backend: organization is required")
+	}
+	for _, block := range backendBlock.Body.Blocks {
+		if block.Type != "workspaces" {
+			continue
+		}
+		for _, target := range []struct {
+			name  string
+			value *string
+		}{{"name", &cfg.name}, {"prefix", &cfg.prefix}} {
+			if _, ok := block.Body.Attributes[target.name]; !ok {
+				continue
+			}
+			if *target.value, err = app.getStringAttribute(block.Body, target.name); err != nil {
+				return tfeConfig{}, fmt.Errorf("remote backend: %w", err)
+			}
+		}
+	}
+	if ws := os.Getenv("TF_WORKSPACE"); ws != "" && cfg.prefix != "" {
+		cfg.name, cfg.prefix = cfg.prefix+ws, ""
+	}
+	if (cfg.name == "") == (cfg.prefix == "") {
+		return tfeConfig{}, fmt.Errorf("remote backend: workspaces requires either name or prefix")
+	}
+	return cfg, nil
+}
+
+// buildTFEConnection reads the settings shared by cloud blocks and remote
+// backends.
+func (app *App) buildTFEConnection(block *hclsyntax.Block, what string) (tfeConfig, error) {
+	cfg := tfeConfig{hostname: defaultTFEHostname}
+	for _, target := range []struct {
+		name  string
+		value *string
+	}{{"hostname", &cfg.hostname}, {"organization", &cfg.organization}, {"token", &cfg.token}} {
+		if _, ok := block.Body.Attributes[target.name]; !ok {
+			continue
+		}
+		value, err := app.getStringAttribute(block.Body, target.name)
+		if err != nil {
+			return tfeConfig{}, fmt.Errorf("%s: %w", what, err)
+		}
+		*target.value = value
+	}
+	return cfg, nil
+}
+
+// parseTags reads the tags of a cloud block's workspaces: either a list of
+// tag names or, in the newer form, a map of key/value tags.
+func parseTags(val cty.Value) ([]string, map[string]string, error) {
+	if val.IsNull() || !val.IsWhollyKnown() {
+		return nil, nil, fmt.Errorf("attribute tags must be a list of strings or a map of strings")
+	}
+	ty := val.Type()
+	switch {
+	case ty.IsListType(), ty.IsSetType(), ty.IsTupleType():
+		var tags []string
+		for it := val.ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			if v.Type() != cty.String || v.IsNull() {
+				return nil, nil, fmt.Errorf("attribute tags must be a list of strings")
+			}
+			tags = append(tags, v.AsString())
+		}
+		return tags, nil, nil
+	case ty.IsMapType(), ty.IsObjectType():
+		bindings := map[string]string{}
+		for it := val.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			if v.Type() != cty.String || v.IsNull() {
+				return nil, nil, fmt.Errorf("attribute tags must be a map of strings")
+			}
+			bindings[k.AsString()] = v.AsString()
+		}
+		return nil, bindings, nil
+	}
+	return nil, nil, fmt.Errorf("attribute tags must be a list of strings or a map of strings")
+}
+
+// tfeSources returns one state source per workspace selected by cfg. A name
+// selects one workspace without contacting the API; a prefix or tags are
+// resolved by listing the organization's workspaces, and matching none is an
+// error, since no states at all would let every block be removed.
+func (app *App) tfeSources(ctx context.Context, cfg tfeConfig) ([]stateSource, error) {
+	var names []string
+	if cfg.name != "" {
+		names = []string{cfg.name}
+	} else {
+		client, err := app.tfeClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if names, err = listTFEWorkspaces(ctx, client, cfg); err != nil {
+			return nil, fmt.Errorf("could not list workspaces of %s on %s: %w", cfg.organization, cfg.hostname, err)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no workspace of %s on %s matches %s", cfg.organization, cfg.hostname, cfg.selection())
+		}
+	}
+
+	sources := make([]stateSource, 0, len(names))
+	for _, name := range names {
+		sources = append(sources, stateSource{
+			name: fmt.Sprintf("remote://%s/%s/%s", cfg.hostname, cfg.organization, name),
+			read: func(ctx context.Context) (*tfstate.TFState, error) {
+				return app.readTFEState(ctx, cfg, name)
+			},
+		})
+	}
+	return sources, nil
+}
+
+func (cfg tfeConfig) selection() string {
+	switch {
+	case cfg.prefix != "":
+		return fmt.Sprintf("prefix %q", cfg.prefix)
+	case len(cfg.tags) > 0:
+		return fmt.Sprintf("tags %q", cfg.tags)
+	}
+	var pairs []string
+	for k, v := range cfg.tagBindings {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("tags %q", pairs)
+}
+
+func listTFEWorkspaces(ctx context.Context, client *tfe.Client, cfg tfeConfig) ([]string, error) {
+	opts := &tfe.WorkspaceListOptions{
+		ListOptions: tfe.ListOptions{PageSize: 100},
+		Search:      cfg.prefix,
+		Tags:        strings.Join(cfg.tags, ","),
+	}
+	for k, v := range cfg.tagBindings {
+		opts.TagBindings = append(opts.TagBindings, &tfe.TagBinding{Key: k, Value: v})
+	}
+	sort.Slice(opts.TagBindings, func(i, j int) bool { return opts.TagBindings[i].Key < opts.TagBindings[j].Key })
+
+	var names []string
+	for {
+		list, err := client.Workspaces.List(ctx, cfg.organization, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, ws := range list.Items {
+			// search[name] matches anywhere in the name; a prefix must not.
+			if strings.HasPrefix(ws.Name, cfg.prefix) {
+				names = append(names, ws.Name)
+			}
+		}
+		if list.Pagination == nil || list.CurrentPage >= list.TotalPages {
+			break
+		}
+		opts.PageNumber = list.NextPage
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readTFEState downloads the current state version of a workspace. A
+// workspace that has never been applied has no state version; like Terraform,
+// it is read as an empty state. The API also answers 404 when the token may
+// not read state versions, so only the workspace itself decides that.
+func (app *App) readTFEState(ctx context.Context, cfg tfeConfig, workspace string) (*tfstate.TFState, error) {
+	client, err := app.tfeClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := client.Workspaces.Read(ctx, cfg.organization, workspace)
+	if err != nil {
+		return nil, fmt.Errorf("could not read workspace %s: %w", workspace, err)
+	}
+	if ws.CurrentStateVersion == nil {
+		return tfstate.Read(ctx, strings.NewReader(`{"version": 4, "resources": []}`))
+	}
+	sv, err := client.StateVersions.ReadCurrent(ctx, ws.ID)
+	if errors.Is(err, tfe.ErrResourceNotFound) {
+		return nil, fmt.Errorf("could not read the current state version of %s: %w (does the token have permission to read state versions?)", workspace, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the current state version of %s: %w", workspace, err)
+	}
+	data, err := client.StateVersions.Download(ctx, sv.DownloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not download the state of %s: %w", workspace, err)
+	}
+	return readState(ctx, bytes.NewReader(data))
+}
+
+func (app *App) tfeClient(cfg tfeConfig) (*tfe.Client, error) {
+	return tfe.NewClient(&tfe.Config{
+		Address:    "https://" + cfg.hostname,
+		Token:      tfeToken(cfg.hostname, cfg.token),
+		HTTPClient: app.httpClient,
+	})
+}
+
+// tfeToken finds the API token for hostname the way Terraform does: the token
+// attribute, then TF_TOKEN_<hostname>, then TFE_TOKEN, then the credentials
+// file written by terraform login.
+func tfeToken(hostname, token string) string {
+	if token != "" {
+		return token
+	}
+	envName := "TF_TOKEN_" + strings.NewReplacer(".", "_", "-", "__").Replace(hostname)
+	if token := os.Getenv(envName); token != "" {
+		return token
+	}
+	if token := os.Getenv("TFE_TOKEN"); token != "" {
+		return token
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".terraform.d", "credentials.tfrc.json"))
+	if err != nil {
+		return ""
+	}
+	var creds struct {
+		Credentials map[string]struct {
+			Token string `json:"token"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return ""
+	}
+	return creds.Credentials[hostname].Token
+}
@@ -0,0 +1,315 @@
+package tfclean
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeTFEWorkspace is a workspace served by fakeTFE. state is the raw state
+// document; an empty state means the workspace has never been applied.
+// stateHidden answers 404 for the current state version of a workspace that
+// has one, as the API does for a token that may not read state versions.
+type fakeTFEWorkspace struct {
+	name        string
+	tags        map[string]string
+	state       string
+	stateHidden bool
+}
+
+// fakeTFE serves the parts of the HCP Terraform API tfclean uses: ping,
+// workspace read and list, current state version and state download. The
+// list endpoint returns one workspace per page to exercise pagination.
+func fakeTFE(t *testing.T, token string, workspaces []fakeTFEWorkspace) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	wsJSON := func(i int) map[string]any {
+		var current any
+		if workspaces[i].state != "" {
+			current = map[string]any{"id": fmt.Sprintf("sv-%d", i), "type": "state-versions"}
+		}
+		return map[string]any{
+			"id":            fmt.Sprintf("ws-%d", i),
+			"type":          "workspaces",
+			"attributes":    map[string]any{"name": workspaces[i].name},
+			"relationships": map[string]any{"current-state-version": map[string]any{"data": current}},
+		}
+	}
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, `{"errors":[{"status":"401","title":"unauthorized"}]}`, http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc("GET /api/v2/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("TFP-API-Version", "2.6")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/v2/organizations/acme/workspaces/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		for i, ws := range workspaces {
+			if ws.name == r.PathValue("name") {
+				writeJSON(w, map[string]any{"data": wsJSON(i)})
+				return
+			}
+		}
+		http.Error(w, `{"errors":[{"status":"404","title":"not found"}]}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("GET /api/v2/organizations/acme/workspaces", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		var matched []int
+		for i, ws := range workspaces {
+			if search := q.Get("search[name]"); !strings.Contains(ws.name, search) {
+				continue
+			}
+			ok := true
+			if tags := q.Get("search[tags]"); tags != "" {
+				for _, tag := range strings.Split(tags, ",") {
+					if _, has := ws.tags[tag]; !has {
+						ok = false
+					}
+				}
+			}
+			for n := 0; q.Has(fmt.Sprintf("filter[tagged][%d][key]", n)); n++ {
+				key := q.Get(fmt.Sprintf("filter[tagged][%d][key]", n))
+				value, has := ws.tags[key]
+				if !has || value != q.Get(fmt.Sprintf("filter[tagged][%d][value]", n)) {
+					ok = false
+				}
+			}
+			if ok {
+				matched = append(matched, i)
+			}
+		}
+		page := 1
+		if p := q.Get("page[number]"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		data := []any{}
+		if page <= len(matched) {
+			data = append(data, wsJSON(matched[page-1]))
+		}
+		var next any
+		if page < len(matched) {
+			next = page + 1
+		}
+		writeJSON(w, map[string]any{
+			"data": data,
+			"meta": map[string]any{"pagination": map[string]any{
+				"current-page": page,
+				"next-page":    next,
+				"total-pages":  max(len(matched), 1),
+				"total-count":  len(matched),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /api/v2/workspaces/{id}/current-state-version", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.PathValue("id"), "ws-"))
+		if i >= len(workspaces) || workspaces[i].state == "" || workspaces[i].stateHidden {
+			http.Error(w, `{"errors":[{"status":"404","title":"not found"}]}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{"data": map[string]any{
+			"id":   fmt.Sprintf("sv-%d", i),
+			"type": "state-versions",
+			"attributes": map[string]any{
+				"hosted-state-download-url": fmt.Sprintf("%s/state/ws-%d", server.URL, i),
+			},
+		}})
+	})
+	mux.HandleFunc("GET /state/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.PathValue("id"), "ws-"))
+		_, _ = w.Write([]byte(workspaces[i].state))
+	})
+
+	server = httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestApp_Run_tfeBackends(t *testing.T) {
+	workspaces := []fakeTFEWorkspace{
+		{name: "app-dev", tags: map[string]string{"app": "", "env": "dev"}, state: stateWithResource("bbb")},
+		{name: "app-prod", tags: map[string]string{"app": "", "env": "prod"}, state: stateWithResource("aaa")},
+		{name: "legacy-app-dev", tags: map[string]string{"legacy": ""}, state: stateWithResource("aaa")},
+		{name: "app-new", tags: map[string]string{"new": ""}},
+		{name: "app-hidden", state: stateWithResource("bbb"), stateHidden: true},
+	}
+	const main = "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	const cleaned = "resource \"time_static\" \"bbb\" {}\n\n"
+
+	tests := []struct {
+		name        string
+		backend     string
+		cli         CLI
+		env         map[string]string
+		wantSources []string
+		want        string
+		wantErr     string
+	}{
+		{
+			name:        "cloud block with a workspace name",
+			backend:     `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { name = "app-dev" } }`,
+			wantSources: []string{"app-dev"},
+			want:        cleaned,
+		},
+		{
+			name:        "cloud block with tags: pending in one workspace",
+			backend:     `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { tags = ["app"] } }`,
+			wantSources: []string{"app-dev", "app-prod"},
+			want:        main,
+		},
+		{
+			name:        "cloud block with key/value tags",
+			backend:     `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { tags = { env = "dev" } } }`,
+			wantSources: []string{"app-dev"},
+			want:        cleaned,
+		},
+		{
+			name:        "cloud block with tags and TF_WORKSPACE",
+			backend:     `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { tags = ["app"] } }`,
+			env:         map[string]string{"TF_WORKSPACE": "app-dev"},
+			wantSources: []string{"app-dev"},
+			want:        cleaned,
+		},
+		{
+			name:        "cloud block with organization and token from the environment",
+			backend:     `cloud { hostname = "HOST"; workspaces { name = "app-dev" } }`,
+			env:         map[string]string{"TF_CLOUD_ORGANIZATION": "acme", "TFE_TOKEN": "secret"},
+			wantSources: []string{"app-dev"},
+			want:        cleaned,
+		},
+		{
+			name:        "remote backend with a prefix matches from the start of the name",
+			backend:     `backend "remote" { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { prefix = "app-d" } }`,
+			wantSources: []string{"app-dev"},
+			want:        cleaned,
+		},
+		{
+			name:        "workspace that has never been applied reads as empty",
+			backend:     `backend "remote" { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { name = "app-new" } }`,
+			wantSources: []string{"app-new"},
+			want:        main,
+		},
+		{
+			name:    "state version not readable with the token",
+			backend: `backend "remote" { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { name = "app-hidden" } }`,
+			wantErr: "could not read the current state version of app-hidden",
+		},
+		{
+			name:    "cloud block with --all-workspaces",
+			backend: `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { name = "app-dev" } }`,
+			cli:     CLI{AllWorkspaces: true},
+			wantErr: "--all-workspaces is not supported for cloud blocks",
+		},
+		{
+			name:    "cloud block with --backend-config",
+			backend: `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { name = "app-dev" } }`,
+			cli:     CLI{BackendConfig: []string{"organization=other"}},
+			wantErr: "--backend-config only applies to a backend block, not to a cloud block",
+		},
+		{
+			name:    "cloud block with --backend-config-dir",
+			backend: `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { name = "app-dev" } }`,
+			cli:     CLI{BackendConfigDir: "env"},
+			wantErr: "--backend-config-dir only applies to a backend block, not to a cloud block",
+		},
+		{
+			name:    "remote backend with --all-workspaces",
+			backend: `backend "remote" { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { name = "app-dev" } }`,
+			cli:     CLI{AllWorkspaces: true},
+			wantErr: "--all-workspaces is not supported for the remote backend",
+		},
+		{
+			name:    "no workspace matches the tags",
+			backend: `cloud { organization = "acme"; hostname = "HOST"; token = "secret"; workspaces { tags = ["missing"] } }`,
+			wantErr: "no workspace of acme",
+		},
+		{
+			name:    "wrong token",
+			backend: `cloud { organization = "acme"; hostname = "HOST"; token = "wrong"; workspaces { name = "app-dev" } }`,
+			wantErr: "could not read state",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"TF_WORKSPACE", "TF_CLOUD_ORGANIZATION", "TF_CLOUD_HOSTNAME", "TFE_TOKEN"} {
+				t.Setenv(name, tt.env[name])
+			}
+			t.Setenv("HOME", t.TempDir())
+			server := fakeTFE(t, "secret", workspaces)
+			host := strings.TrimPrefix(server.URL, "https://")
+
+			dir := t.TempDir()
+			backend := strings.NewReplacer("; ", "\n", "{ ", "{\n", " }", "\n}", "HOST", host).Replace(tt.backend)
+			files := map[string]string{
+				"backend.tf": "terraform {\n" + backend + "\n}\n",
+				"main.tf":    main,
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("write fixture: %v", err)
+				}
+			}
+
+			cli := tt.cli
+			cli.Dir = dir
+			app := New(&cli)
+			app.httpClient = server.Client()
+			err := app.Run(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			sources, err := app.detectBackendFromConfig(t.Context())
+			if err != nil {
+				t.Fatalf("detectBackendFromConfig() error = %v", err)
+			}
+			var want []string
+			for _, ws := range tt.wantSources {
+				want = append(want, "remote://"+host+"/acme/"+ws)
+			}
+			if got := sourceNames(sources); got != strings.Join(want, ", ") {
+				t.Errorf("detectBackendFromConfig() = %v, want %v", got, want)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("main.tf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
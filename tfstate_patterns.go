@@ -0,0 +1,150 @@
+package tfclean
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// hasGlobMeta reports whether s contains any of the special characters of
+// path.Match.
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// tfstateSources expands one --tfstate value into the states it names. A
+// local directory names every *.tfstate file below it, and a glob pattern
+// names every local file or s3 object it matches. Any other value is a single
+// state. An expansion that finds nothing is an error, like a state that cannot
+// be read.
+func (app *App) tfstateSources(ctx context.Context, value string) ([]stateSource, error) {
+	if scheme, rest, ok := strings.Cut(value, "://"); ok {
+		if !hasGlobMeta(value) {
+			if bucket, key, ok := strings.Cut(rest, "/"); ok && scheme == "s3" {
+				// Read with the same client a pattern lists with. Like
+				// tfstate.ReadURL it looks up the bucket region and honours
+				// AWS_ENDPOINT_URL_S3, and it also accepts terraform show
+				// -json output.
+				return []stateSource{app.s3Source(value, s3Config{bucket: bucket}, key)}, nil
+			}
+			return []stateSource{urlSource(value)}, nil
+		}
+		if scheme != "s3" {
+			return nil, fmt.Errorf("%s: patterns are only supported for local paths and s3:// URLs", value)
+		}
+		return app.s3PatternSources(ctx, value, rest)
+	}
+
+	if !hasGlobMeta(value) {
+		info, err := os.Stat(value)
+		if err != nil || !info.IsDir() {
+			return []stateSource{urlSource(value)}, nil
+		}
+		sources, err := stateFilesIn(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("no *.tfstate file in %s", value)
+		}
+		return sources, nil
+	}
+
+	matches, err := filepath.Glob(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", value, err)
+	}
+	var sources []stateSource
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			sources = append(sources, urlSource(match))
+			continue
+		}
+		found, err := stateFilesIn(match)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, found...)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no state matches %s", value)
+	}
+	return sources, nil
+}
+
+// stateFilesIn returns every *.tfstate file below dir, in lexical order.
+// Hidden directories such as .terraform, whose terraform.tfstate only records
+// the backend, are skipped.
+func stateFilesIn(dir string) ([]stateSource, error) {
+	var sources []stateSource
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) == ".tfstate" {
+			sources = append(sources, urlSource(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// s3PatternSources lists the objects of an s3://bucket/pattern URL whose keys
+// match the pattern. As in path.Match, * does not match "/".
+func (app *App) s3PatternSources(ctx context.Context, value, rest string) ([]stateSource, error) {
+	bucket, pattern, _ := strings.Cut(rest, "/")
+	if bucket == "" || hasGlobMeta(bucket) {
+		return nil, fmt.Errorf("%s: the bucket name cannot be a pattern", value)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", value, err)
+	}
+	prefix := pattern[:strings.IndexAny(pattern, "*?[")]
+
+	cfg := s3Config{bucket: bucket}
+	client, err := app.s3Client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var sources []stateSource
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not list s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if ok, _ := path.Match(pattern, key); ok {
+				sources = append(sources, app.s3Source(fmt.Sprintf("s3://%s/%s", bucket, key), cfg, key))
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no state matches %s", value)
+	}
+	return sources, nil
+}
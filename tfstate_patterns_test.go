@@ -0,0 +1,188 @@
+package tfclean
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApp_tfstateSources_local(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"states/alice/terraform.tfstate":          stateWithResource("a"),
+		"states/bob/terraform.tfstate":            stateWithResource("a"),
+		"states/bob/terraform.tfstate.backup":     stateWithResource("a"),
+		"states/bob/.terraform/terraform.tfstate": `{"backend": {"type": "local"}}`,
+		"states/carol.tfstate":                    stateWithResource("a"),
+		"empty/README.md":                         "",
+	})
+
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "plain file",
+			value: "states/carol.tfstate",
+			want:  []string{"states/carol.tfstate"},
+		},
+		{
+			name:  "missing file is left to the reader",
+			value: "states/dave.tfstate",
+			want:  []string{"states/dave.tfstate"},
+		},
+		{
+			name:  "directory",
+			value: "states",
+			want:  []string{"states/alice/terraform.tfstate", "states/bob/terraform.tfstate", "states/carol.tfstate"},
+		},
+		{
+			name:  "glob",
+			value: "states/*/terraform.tfstate",
+			want:  []string{"states/alice/terraform.tfstate", "states/bob/terraform.tfstate"},
+		},
+		{
+			name:  "glob matching directories",
+			value: "states/[ab]*",
+			want:  []string{"states/alice/terraform.tfstate", "states/bob/terraform.tfstate"},
+		},
+		{
+			name:    "directory without states",
+			value:   "empty",
+			wantErr: "no *.tfstate file in",
+		},
+		{
+			name:    "glob matching nothing",
+			value:   "states/*/prod.tfstate",
+			wantErr: "no state matches",
+		},
+		{
+			name:    "pattern in an unsupported scheme",
+			value:   "gs://bucket/*/terraform.tfstate",
+			wantErr: "patterns are only supported for local paths and s3:// URLs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := tt.value
+			if !strings.Contains(value, "://") {
+				value = filepath.Join(dir, value)
+			}
+			sources, err := New(&CLI{}).tfstateSources(t.Context(), value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("tfstateSources() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tfstateSources() error = %v", err)
+			}
+			var want []string
+			for _, name := range tt.want {
+				want = append(want, filepath.Join(dir, name))
+			}
+			if got := sourceNames(sources); got != strings.Join(want, ", ") {
+				t.Errorf("tfstateSources() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_Run_tfstatePatterns(t *testing.T) {
+	isolateAWSEnv(t)
+	server := newFakeS3(t, map[string]string{
+		"states/dev/alice/terraform.tfstate":   stateWithResource("bbb"),
+		"states/dev/bob/terraform.tfstate":     stateWithResource("bbb"),
+		"states/dev/bob/old/terraform.tfstate": stateWithResource("aaa"),
+		"states/prod/terraform.tfstate":        stateWithResource("aaa"),
+		"states/dev/carol/terraform.tfstate":   stateWithResource("aaa"),
+	})
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+
+	const main = "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"
+	tests := []struct {
+		name    string
+		tfstate string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "applied in every match",
+			tfstate: "s3://states/dev/[ab]*/terraform.tfstate",
+			want:    "resource \"time_static\" \"bbb\" {}\n\n",
+		},
+		{
+			name:    "one match still behind",
+			tfstate: "s3://states/dev/*/terraform.tfstate",
+			want:    main,
+		},
+		{
+			name:    "no match",
+			tfstate: "s3://states/staging/*/terraform.tfstate",
+			wantErr: "no state matches s3://states/staging/*/terraform.tfstate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.tf": main})
+			err := New(&CLI{Dir: dir, Tfstate: []string{tt.tfstate}}).Run(t.Context())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("main.tf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestApp_Run_tfstateS3Endpoint reads plain and pattern s3:// values through
+// AWS_ENDPOINT_URL_S3 with a hostname, as with MinIO or LocalStack, so only
+// path-style requests reach the bucket. A state may also be terraform show
+// -json output.
+func TestApp_Run_tfstateS3Endpoint(t *testing.T) {
+	isolateAWSEnv(t)
+	server := newFakeS3(t, map[string]string{
+		"states/app/terraform.tfstate": stateWithResource("bbb"),
+		"states/audit/state.json":      showJSONState,
+	})
+	t.Setenv("AWS_ENDPOINT_URL_S3", "http://minio.test:9000")
+
+	for _, tfstate := range []string{
+		"s3://states/app/terraform.tfstate",
+		"s3://states/audit/state.json",
+		"s3://states/*/terraform.tfstate",
+	} {
+		t.Run(tfstate, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.tf": "resource \"time_static\" \"bbb\" {}\n\nmoved {\n  from = time_static.aaa\n  to   = time_static.bbb\n}\n"})
+			app := New(&CLI{Dir: dir, Tfstate: []string{tfstate}})
+			app.httpClient = dialingClient(server.Server)
+			if err := app.Run(t.Context()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "resource \"time_static\" \"bbb\" {}\n\n"; string(got) != want {
+				t.Errorf("main.tf = %q, want %q", got, want)
+			}
+		})
+	}
+}